@@ -0,0 +1,439 @@
+package main
+
+import (
+	"gopkg.in/telebot.v3"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// localeFormat is how a language writes plurals, numbers and dates
+type localeFormat struct {
+	One     func(n int64) bool // plural rule, true when the singular form is used
+	Group   string             // thousands separator
+	Decimal string
+	Date    string // {day}, {month} (abbreviated) and {year}
+	Months  [12]string
+}
+
+// localeFormats are the formats of the locales, the other locales (pseudo...) use en
+var localeFormats = map[string]localeFormat{
+	"en": {
+		One:   func(n int64) bool { return n == 1 },
+		Group: ",", Decimal: ".",
+		Date:   "{month} {day}, {year}",
+		Months: [12]string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"},
+	},
+	"fr": {
+		One:   func(n int64) bool { return n == 0 || n == 1 },
+		Group: "\u202f", Decimal: ",",
+		Date:   "{day} {month} {year}",
+		Months: [12]string{"janv.", "févr.", "mars", "avr.", "mai", "juin", "juil.", "août", "sept.", "oct.", "nov.", "déc."},
+	},
+	"de": {
+		One:   func(n int64) bool { return n == 1 },
+		Group: ".", Decimal: ",",
+		Date:   "{day}. {month} {year}",
+		Months: [12]string{"Jan.", "Feb.", "März", "Apr.", "Mai", "Juni", "Juli", "Aug.", "Sept.", "Okt.", "Nov.", "Dez."},
+	},
+	"es": {
+		One:   func(n int64) bool { return n == 1 },
+		Group: ".", Decimal: ",",
+		Date:   "{day} {month} {year}",
+		Months: [12]string{"ene", "feb", "mar", "abr", "may", "jun", "jul", "ago", "sept", "oct", "nov", "dic"},
+	},
+	"pt": {
+		One:   func(n int64) bool { return n == 0 || n == 1 },
+		Group: ".", Decimal: ",",
+		Date:   "{day} de {month} de {year}",
+		Months: [12]string{"jan.", "fev.", "mar.", "abr.", "mai.", "jun.", "jul.", "ago.", "set.", "out.", "nov.", "dez."},
+	},
+}
+
+// templateFuncs format the data in the language of the template, which is given by the locale function:
+// {{ plural locale .Days "day" "days" }}, {{ number locale .Count }}, {{ date locale .Start }}, {{ datetime locale .CreatedAt }}
+var templateFuncs = template.FuncMap{
+	"plural":   formatPlural,
+	"number":   formatNumber,
+	"date":     formatDate,
+	"datetime": formatDateTime,
+}
+
+func formatOf(locale string) localeFormat {
+	if format, ok := localeFormats[locale]; ok {
+		return format
+	}
+
+	return localeFormats["en"]
+}
+
+// formatPlural returns the formatted number followed by the singular or plural form
+func formatPlural(locale string, n any, one, other string) string {
+	var value int64
+
+	switch v := reflect.ValueOf(n); v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value = v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		value = int64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		value = int64(v.Float())
+		if float64(value) != v.Float() {
+			return formatNumber(locale, n) + " " + other
+		}
+	}
+
+	if value < 0 {
+		value = -value
+	}
+
+	if formatOf(locale).One(value) {
+		return formatNumber(locale, n) + " " + one
+	}
+
+	return formatNumber(locale, n) + " " + other
+}
+
+// formatNumber separates the thousands of the number and uses the decimal separator of the locale
+func formatNumber(locale string, n any) string {
+	format := formatOf(locale)
+
+	// named types (time.Duration...) are formatted by their kind, not their String method
+	var text string
+
+	switch v := reflect.ValueOf(n); v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		text = strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		text = strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		text = strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits())
+	default:
+		text = fmt.Sprint(n)
+	}
+
+	sign := ""
+	if strings.HasPrefix(text, "-") {
+		sign, text = "-", text[1:]
+	}
+
+	integer, decimals, _ := strings.Cut(text, ".")
+
+	var grouped strings.Builder
+	for i, digit := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			grouped.WriteString(format.Group)
+		}
+		grouped.WriteRune(digit)
+	}
+
+	if decimals != "" {
+		return sign + grouped.String() + format.Decimal + decimals
+	}
+
+	return sign + grouped.String()
+}
+
+// formatDate returns the date in the format of the locale, or nothing for the zero time
+func formatDate(locale string, t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	format := formatOf(locale)
+
+	return strings.NewReplacer(
+		"{day}", strconv.Itoa(t.Day()),
+		"{month}", format.Months[t.Month()-1],
+		"{year}", strconv.Itoa(t.Year()),
+	).Replace(format.Date)
+}
+
+// formatDateTime returns the date and the time (24h) in the format of the locale
+func formatDateTime(locale string, t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return formatDate(locale, t) + " " + t.Format("15:04")
+}
+
+// localeSamples are examples of the data the handlers pass to the templates, every template using
+// the data must have one so checkLocales can execute it (add one with the new texts)
+var localeSamples = func() map[string]any {
+	now := time.Now()
+	numbers := map[string]int{"Min": 1, "Max": 10}
+
+	task := Task{Model: gorm.Model{CreatedAt: now}, Text: "Walk 30 minutes", Status: "done", IsDone: true}
+	entry := Entry{Model: gorm.Model{CreatedAt: now}, Note: 7, Text: "Good day"}
+	habit := Habit{Name: "Read", Frequency: 3, Points: 2}
+	motivation := Motivation{ID: "1", Pack: "pack", Category: "quotes", Language: "en", Type: "photo", Caption: "Caption"}
+	errorLog := ErrorLog{Model: gorm.Model{ID: 1, CreatedAt: now}, UserID: 1, Command: "/check", Error: "error", Kind: "error"}
+	broadcast := Broadcast{Model: gorm.Model{ID: 1}, Language: "en", Journey: "yes", RankSystem: "memes", Inactive: 7, ScheduledAt: now, Status: "done", Sent: 10}
+	taskData := TaskData{Model: gorm.Model{ID: 1}, Points: 5, Category: "fitness", Difficulty: 2, Duration: 30, RequiresProof: true,
+		Texts: []TaskText{{Language: "en", Text: "Walk 30 minutes"}}}
+
+	return map[string]any{
+		"account-activity-text": []Activity{
+			{Type: "journey", Item: Journey{Model: gorm.Model{CreatedAt: now}}},
+			{Type: "entry", Item: entry},
+			{Type: "task", Item: task},
+			{Type: "rank-up", Item: RankUp{Model: gorm.Model{CreatedAt: now}, Rank: "Scout", Level: 1}},
+		},
+		"account-rank-ask":     Rank{Name: "Memes"},
+		"account-rank-changed": map[string]any{"RankSystem": "Memes", "Rank": "Doomer"},
+		"account-score-text":   []ScoreEvent{{Type: "day", Points: 2, Date: now}, {Type: "admin", Points: -5, Reason: "spam", Date: now}},
+		"account-text": map[string]any{
+			"Score": Score{Days: 10, CheckIns: 3, Bonus: 2, Admin: -1}, "CurrentRank": "Scout", "NextRank": "Private",
+			"DaysLeft": 2, "TotalDays": 10, "AverageDays": 5, "EntriesCount": 3, "TasksCount": 1,
+		},
+		"admin-broadcast-invalid-filter":  "rank nope",
+		"admin-broadcast-invalid-message": "Bad Request: can't parse entities",
+		"admin-broadcast-preview":         map[string]any{"Broadcast": broadcast, "Audience": 10, "Schedule": now},
+		"admin-broadcast-scheduled":       now,
+		"admin-broadcast-report":          broadcast,
+		"admin-broadcasts":                []Broadcast{broadcast},
+		"admin-challenge-ask-category":    []string{"fitness"},
+		"admin-challenge-ask-check-ins":   numbers,
+		"admin-challenge-ask-days":        numbers,
+		"admin-challenge-ask-points":      numbers,
+		"admin-challenge-ask-tasks":       numbers,
+		"admin-config-get": map[string]any{
+			"Key": "task_rerolls", "Type": "int", "Value": "1",
+			"Changes": []SettingChange{{Model: gorm.Model{CreatedAt: now}, UserID: 1, Key: "task_rerolls", Old: "1", New: "2"}},
+		},
+		"admin-config-invalid":     map[string]any{"Key": "task_rerolls", "Type": "int", "Error": "invalid syntax"},
+		"admin-config-list":        map[string]any{"Values": map[string]string{"task_rerolls": "2"}, "Overrides": map[string]string{"task_rerolls": "2"}},
+		"admin-config-set":         map[string]any{"Key": "task_rerolls", "Old": "1", "New": "2"},
+		"admin-config-unknown":     "nope",
+		"admin-error":              errorLog,
+		"admin-error-convert-atoi": "ten",
+		"admin-errors":             []ErrorLog{errorLog},
+		"admin-errors-digest":      map[string]any{"Errors": []ErrorLog{errorLog}, "Counts": map[string]int{"error": 2}},
+		"admin-points-done":        map[string]any{"Username": "user", "Points": 10},
+		"admin-rank-created":       RankSystem{Key: "memes", Name: "Memes", Levels: []RankLevel{{Days: 0, Name: "Coomer"}}},
+		"admin-rank-invalid-level": "ten Scout",
+		"admin-reload-failed":      "bot.yml: yaml: line 1",
+		"admin-reload-success":     map[string]any{"Locales": []string{"en", "fr"}, "Ranks": 2},
+		"admin-stats-users": map[string]any{
+			"Total": 10, "Daily": 1, "Weekly": 3, "Monthly": 5, "Inactive": 4, "Blocked": 1, "Churn": 50,
+			"Cohorts": []struct {
+				Week      string
+				Users     int
+				Retention []int
+			}{{Week: "01 Jan", Users: 2, Retention: []int{100, 50}}},
+		},
+		"admin-task-ask-category":    []string{"fitness"},
+		"admin-task-ask-difficulty":  numbers,
+		"admin-task-ask-duration":    numbers,
+		"admin-task-ask-points":      numbers,
+		"admin-task-ask-text":        "en",
+		"admin-task-created":         taskData,
+		"admin-task-invalid-number":  numbers,
+		"admin-tasks":                map[string]any{"Tasks": []TaskData{taskData}, "Locale": "en"},
+		"badge-awarded":              map[string]any{"Emoji": "🌱", "Name": "First check-in", "Description": "1 check-in"},
+		"badge-type-check-in-streak": 10,
+		"badge-type-check-ins":       10,
+		"badge-type-tasks":           10,
+		"badge-type-urges":           10,
+		"badge-type-weekends":        10,
+		"challenge-results":          map[string]any{"Name": "Week", "Points": 50, "IsCompleted": true, "Winners": []string{"user"}, "Participants": 3},
+		"challenge-text": map[string]any{
+			"Name": "Week", "Category": "Fitness", "Tasks": 5, "CheckIns": 7, "Points": 50, "Start": now, "End": now.AddDate(0, 0, 7),
+			"Participants": 3, "IsJoined": true, "TasksDone": 2, "TasksBar": "▰▰▱▱▱", "CheckInsDone": 3, "CheckInsBar": "▰▰▱▱▱", "IsCompleted": false,
+		},
+		"habits-ask-points":     5,
+		"habits-created":        habit,
+		"habits-done":           habit,
+		"habits-invalid-number": numbers,
+		"habits-text": []struct {
+			Habit
+			Done   int
+			Streak int
+		}{{Habit: habit, Done: 1, Streak: 2}},
+		"habits-too-much": 5,
+		"help-text": map[string]any{
+			"UsersCount": 10, "MessageCount": 100, "AverageResponseTime": time.Second, "Uptime": now,
+			"NofapChannel": "t.me/channel", "PersonalChannel": "t.me/channel",
+		},
+		"inline-streak-text":           map[string]any{"Username": "user", "Days": 10, "Rank": "Scout"},
+		"language-changed":             "English",
+		"language-text":                map[string]any{"Language": "English", "Auto": true},
+		"motivation-caption":           motivation,
+		"motivation-error":             "quote",
+		"motivation-favorites-caption": map[string]any{"Page": 1, "MaxPage": 2, "Caption": "caption"},
+		"motivation-list":              map[string]int{"quotes": 2},
+		"motivation-not-found":         "quote",
+		"motivation-search-results":    map[string]any{"Text": "quote", "Count": 2, "Shown": 2},
+		"motivation-subscribe-invalid": "nope",
+		"motivation-subscribed":        map[string]any{"Category": "quotes", "Time": "09:00", "Timezone": "Europe/Paris"},
+		"new-ask-rank":                 map[string]any{"Start": now},
+		"new-saved":                    map[string]any{"Rank": "Scout", "RankSystem": "Memes", "Start": now, "Days": 10},
+		"profile-button":               User{Username: "user"},
+		"profile-entries":              map[string]any{"User": "user", "Page": 1, "MaxPage": 2, "Privacy": "public", "Entries": []Entry{entry}},
+		"profile-text": map[string]any{
+			"Username": "user", "TotalScore": 100, "JourneyIsCurrent": "Current journey", "CurrentScore": 50, "Start": now,
+			"Days": 10, "CurrentRank": "Scout", "NextRank": "Private", "DaysLeft": 2, "EntriesCount": 3, "TasksCount": 1,
+			"JourneysCount": 2, "AverageDays": 5, "TotalDays": 10, "TotalEntriesCount": 3, "TotalTasksCount": 1, "Badges": []string{"🌱 First check-in"},
+		},
+		"rank-up":                map[string]any{"Rank": "Scout", "Description": "", "Days": 1, "NextRank": "Private", "DaysLeft": 2},
+		"score-adjusted":         map[string]any{"Points": 10, "Reason": "event"},
+		"survived-saved":         map[string]any{"Privacy": "public", "Note": 7, "Command": "/profile", "Text": "Good day"},
+		"task-cta":               map[string]any{"Task": "Walk", "Now": now, "Deadline": now, "Category": "Fitness", "Difficulty": "⭐", "Duration": 30},
+		"task-done":              map[string]any{"Task": "Walk", "GivenAt": now, "DoneAt": now, "Points": 5, "ProofStatus": "pending"},
+		"task-expired":           task,
+		"task-no-reroll":         1,
+		"task-proof-approved":    task,
+		"task-proof-rejected":    task,
+		"task-proof-review":      map[string]any{"Username": "user", "Task": "Walk", "Text": "Done"},
+		"task-proof-reviewed-by": &telebot.User{FirstName: "Admin"},
+		"task-skipped":           task,
+		"task-unknown-category":  []string{"fitness"},
+		"urge-saved":             int64(3),
+	}
+}()
+
+// checkLocales checks that every locale file has the keys of the others, that no text is empty and that
+// the templates execute with the data of localeSamples, it returns every problem found
+func checkLocales(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.yml"))
+	if err != nil {
+		return err
+	}
+
+	locales := make(map[string]map[string]string)
+	keys := make(map[string]bool)
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		var texts map[string]string
+		if err := yaml.Unmarshal(data, &texts); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+
+		locale := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		locales[locale] = texts
+
+		// the pseudo-locale is generated from en and may be older, it's not checked for the keys
+		if locale == pseudoLocale {
+			continue
+		}
+
+		for key := range texts {
+			keys[key] = true
+		}
+	}
+
+	var problems []string
+
+	for locale, texts := range locales {
+		funcs := template.FuncMap{
+			"locale": func() string { return locale },
+			"config": func(string) string { return "" },
+			"text":   func(string, ...interface{}) string { return "" },
+		}
+
+		for name, f := range templateFuncs {
+			funcs[name] = f
+		}
+
+		for key := range keys {
+			text, ok := texts[key]
+			if !ok && locale == pseudoLocale {
+				continue
+			} else if !ok {
+				problems = append(problems, fmt.Sprintf("%s: missing %s", locale, key))
+				continue
+			}
+
+			if strings.TrimSpace(text) == "" {
+				problems = append(problems, fmt.Sprintf("%s: empty %s", locale, key))
+				continue
+			}
+
+			tmpl, err := template.New(key).Funcs(funcs).Option("missingkey=error").Parse(strings.Trim(text, "\r\n"))
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", locale, err))
+				continue
+			}
+
+			if err := tmpl.Execute(io.Discard, localeSamples[key]); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", locale, err))
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	sort.Strings(problems)
+
+	return fmt.Errorf("%d locale problems:\n%s", len(problems), strings.Join(problems, "\n"))
+}
+
+// pseudoLocale is the name of the generated locale, see writePseudoLocale
+const pseudoLocale = "pseudo"
+
+// pseudoLetters replaces the letters of the pseudo-locale, texts still readable but obviously not translated
+var pseudoLetters = strings.NewReplacer(
+	"a", "á", "e", "é", "i", "í", "o", "ö", "u", "ü", "y", "ý", "c", "ç", "n", "ñ",
+	"A", "Å", "E", "É", "I", "Î", "O", "Ø", "U", "Û", "C", "Ç", "N", "Ñ",
+)
+
+// pseudoKept are the parts of the texts kept as is: template actions, code, links, commands and mentions
+var pseudoKept = regexp.MustCompile("{{.*?}}|`[^`]*`|<[^>]*>|\\]\\([^)]*\\)|https?://\\S*|t\\.me/\\S*|[/@]\\w[\\w-]*")
+
+// writePseudoLocale writes the pseudo-locale of the source locale file: letters are accented and texts wrapped
+// in ⟦ ⟧, so untranslated (or concatenated) texts stand out when using it with /language
+func writePseudoLocale(source, destination string) error {
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return err
+	}
+
+	var texts map[string]string
+	if err := yaml.Unmarshal(data, &texts); err != nil {
+		return err
+	}
+
+	for key, text := range texts {
+		var pseudo strings.Builder
+
+		last := 0
+		for _, kept := range pseudoKept.FindAllStringIndex(text, -1) {
+			pseudo.WriteString(pseudoLetters.Replace(text[last:kept[0]]))
+			pseudo.WriteString(text[kept[0]:kept[1]])
+			last = kept[1]
+		}
+
+		pseudo.WriteString(pseudoLetters.Replace(text[last:]))
+
+		trimmed := strings.TrimRight(pseudo.String(), "\n")
+		texts[key] = "⟦" + trimmed + "⟧" + strings.Repeat("\n", len(pseudo.String())-len(trimmed))
+	}
+
+	texts["language-name"] = "🏴 Pseudo"
+
+	data, err = yaml.Marshal(texts)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(destination, data, 0644)
+}
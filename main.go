@@ -3,6 +3,7 @@ package main
 import (
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/qwaykee/cauliflower"
 	"github.com/schollz/closestmatch"
@@ -14,17 +15,28 @@ import (
 	"bytes"
 	_ "embed"
 	"errors"
+	"fmt"
 	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"html"
 	"io/fs"
 	"log"
 	"math/rand"
+	"os"
 	"path/filepath"
+	"regexp"
+	"runtime/debug"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
+// number of extra views a disliked motivation counts for
+const motivationDislikeWeight = 3
+
 var (
 	db        *gorm.DB
 	lt        *layout.Layout
@@ -36,40 +48,117 @@ var (
 	responseTime          []time.Duration
 	motivationsCategories = make(map[string]int)
 	notesMarkup           *telebot.ReplyMarkup
-	ranksMarkup           *telebot.ReplyMarkup
 	start                 time.Time
-	usersLanguage 		  = make(map[int64]string)
+	usersLanguage         = make(map[int64]string) // cache of the locales of the users
+	languageMutex         sync.RWMutex
+	seenUsers             = make(map[int64]User) // last users saved by seeUser
+	seenMutex             sync.Mutex
+	sendTicker            = time.NewTicker(time.Second / 25)
+
+	// motivation file extension -> media type
+	motivationTypes = map[string]string{
+		"jpg":  "photo",
+		"jpeg": "photo",
+		"png":  "photo",
+		"webp": "photo",
+		"mp4":  "video",
+		"mov":  "video",
+		"webm": "video",
+		"gif":  "animation",
+		"txt":  "text",
+		"md":   "markdown",
+	}
+
+	// conversation flows: the handler of the current state receives the text messages of the user,
+	// buttons check the state of the conversation themselves
+	flows = map[string]map[string]func(c telebot.Context, conv *Conversation) error{
+		"new":   {"streak": newStreak},
+		"check": {"reason": checkRelapseReason, "entry": checkEntry},
+	}
 
-	// config
-	owners []int64
+	// the layout, ranks, badges, scoring and overrides are replaced together when reloading, under configMutex,
+	// owners and task categories are read with the settings overrides (configInt64s, configStrings)
 	ranks = make(map[string]Rank)
+	badges = make(map[string]Badge)
+	scoring Scoring
+	configMutex sync.RWMutex
+
+	// bot.yml keys overridable with /config and their type, overrides are the saved values
+	settingTypes = map[string]string{
+		"channels.bot":          "string",
+		"channels.personal":     "string",
+		"owners":                "int64s",
+		"task_categories":       "strings",
+		"habit_max_points":      "int",
+		"habit_max_count":       "int",
+		"task_rerolls":          "int",
+		"proof_review_chat":     "int64",
+		"rank_up_motivation":    "bool",
+		"conversation_timeout":  "duration",
+		"error_digest_interval": "duration",
+		"inactive_days":         "int",
+	}
+	overrides = make(map[string]string)
 )
 
 func init() {
 	var err error
 	log.Println("initialization")
 
-	// load layout
-	lt, err = layout.New("bot.yml")
-	if err != nil {
-		log.Fatalf("layout: %v", err)
+	// ./main pseudo-locale generates locales/pseudo.yml, run it again after changing en.yml
+	if len(os.Args) > 1 && os.Args[1] == "pseudo-locale" {
+		if err := writePseudoLocale("locales/en.yml", "locales/pseudo.yml"); err != nil {
+			log.Fatalf("pseudo-locale: %v", err)
+		}
 	}
 
-	if err := lt.UnmarshalKey("owners", &owners); err != nil {
-		log.Fatalf("layout owners: %v", err)
+	// load layout
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatalf("%v", err)
 	}
 
-	if err := lt.UnmarshalKey("ranks", &ranks); err != nil {
-		log.Fatalf("layout ranks: %v", err)
+	// ./main check-locales only checks the config and the locales (for the CI), the bot isn't started
+	if len(os.Args) > 1 && (os.Args[1] == "check-locales" || os.Args[1] == "pseudo-locale") {
+		log.Println("config and locales ok")
+		os.Exit(0)
 	}
 
 	// initialize database
-	db, err = gorm.Open(sqlite.Open(lt.String("database")), &gorm.Config{PrepareStmt: true})
+	db, err = gorm.Open(sqlite.Open(config.Layout.String("database")), &gorm.Config{PrepareStmt: true})
 	if err != nil {
 		log.Fatalf("gorm: %v", err)
 	}
 
-	db.AutoMigrate(&User{}, &Journey{}, &Entry{}, &Task{}, &Motivation{}, &TaskData{})
+	// running journeys created before rank-ups were notified start at the level they already reached
+	backfillRanks := !db.Migrator().HasColumn(&Journey{}, "rank_level")
+
+	db.AutoMigrate(&User{}, &Journey{}, &Entry{}, &Task{}, &Motivation{}, &MotivationView{}, &Subscription{}, &TaskData{}, &TaskText{}, &Habit{}, &Challenge{}, &ChallengeParticipant{}, &UserBadge{}, &Urge{}, &ScoreEvent{}, &RankUp{}, &RankSystem{}, &RankLevel{}, &CallbackToken{}, &Conversation{}, &ErrorLog{}, &Broadcast{}, &BroadcastDelivery{}, &Setting{}, &SettingChange{})
+
+	// database errors are logged even where r.Error is ignored
+	logDatabaseErrors()
+
+	settings, err := readSettings()
+	if err != nil {
+		log.Fatalf("settings: %v", err)
+	}
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return seedRanks(tx, config.Ranks, false)
+	}); err != nil {
+		log.Fatalf("ranks: %v", err)
+	}
+
+	systems, err := readRanks(db)
+	if err != nil {
+		log.Fatalf("ranks: %v", err)
+	}
+
+	useConfig(config, settings, systems)
+
+	if backfillRanks {
+		backfillRankLevels()
+	}
 
 	// load motivation images into db and closest matches
 	if err := update(); err != nil {
@@ -95,40 +184,25 @@ func init() {
 		DefaultListen: &cauliflower.ListenOptions{
 			Cancel: "/cancel",
 			TimeoutHandler: func(c telebot.Context) error {
-				return c.Send(lt.Text(c, "err-no-message-received"))
-			},
-			CancelHandler: func(c telebot.Context) error {
-				return c.Send(lt.Text(c, "err-command-canceled"))
+				return c.Send(localeText(c, "err-no-message-received"))
 			},
+			// listeners get /cancel before the handlers, so it's the same command as outside of them
+			CancelHandler: commandCancel,
 		},
 	})
 	if err != nil {
 		log.Fatalf("cauliflower: %v", err)
 	}
 
-	notesMarkup, err = i.Keyboard(&cauliflower.KeyboardOptions{
-		Keyboard: cauliflower.Inline,
-		Row: []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"},
-		Split: 5,
-		Handler: markupCheckSurvivedNote,
-	})
-	if err != nil {
-		log.Fatalf("keyboard: %v", err)
-	}
-
-	data := make([]string, len(ranks))
-	for _, rank := range ranks {data = append(data, rank.Name)}
+	notesMarkup = b.NewMarkup()
 
-	ranksMarkup, err = i.Keyboard(&cauliflower.KeyboardOptions{
-		Keyboard: cauliflower.Inline,
-		Row: data,
-		Split: 2,
-		Handler: markupNew,
-	})
-	if err != nil {
-		log.Fatalf("keyboard: %v", err)
+	var notes []telebot.Btn
+	for note := 1; note <= 10; note++ {
+		notes = append(notes, notesMarkup.Data(strconv.Itoa(note), "check-note", strconv.Itoa(note)))
 	}
 
+	notesMarkup.Inline(notesMarkup.Split(5, notes)...)
+
 	start = time.Now()
 }
 
@@ -140,9 +214,7 @@ func main() {
 
 			messageCount += 1
 
-			if _, ok := usersLanguage[c.Chat().ID]; !ok {
-				usersLanguage[c.Chat().ID] = c.Sender().LanguageCode
-			}
+			seeUser(c.Sender())
 
 			err := next(c)
 
@@ -152,139 +224,132 @@ func main() {
 		}
 	})
 
-	b.Use(lt.Middleware("fr", func(r telebot.Recipient) string {
-		userID, err := strconv.ParseInt(r.Recipient(), 10, 64)
-		if err != nil {
-			log.Printf("i18n middleware strconv: %v", err)
-		}
-
-		if lang, ok := usersLanguage[userID]; ok {
-			return lang
-		}
-
-		return "fr"
-	}))
+	b.Use(reportErrors)
 
 	b.Use(middleware.AutoRespond())
 
 	b.Handle("/start", commandStart)
 	b.Handle("/new", commandNew)
 	b.Handle("/check", commandCheck)
+	b.Handle("/cancel", commandCancel)
+	b.Handle(telebot.OnText, onText)
+	b.Handle("/urge", commandUrge)
 	b.Handle("/task", commandTask)
 	b.Handle("/motivation", commandMotivation)
 	b.Handle("/profile", commandProfile)
 	b.Handle("/account", commandAccount)
 	b.Handle("/ranks", commandRanks)
 	b.Handle("/help", commandHelp)
+	b.Handle("/language", commandLanguage)
+	b.Handle(&telebot.Btn{Unique: "language-set"}, markupLanguageSet)
 	b.Handle("/fix", commandFix)
+	b.Handle("/habits", commandHabits)
+	b.Handle("/challenge", commandChallenge)
 
-	admin := b.Group()
+	b.Handle(telebot.OnQuery, commandInline)
 
-	admin.Use(middleware.Whitelist(owners...))
+	b.Handle(&telebot.Btn{Unique: "task-done"}, markupTaskDone)
+	b.Handle(&telebot.Btn{Unique: "task-skip"}, markupTaskSkip)
+	b.Handle(&telebot.Btn{Unique: "task-reroll"}, markupTaskReroll)
+	b.Handle(&telebot.Btn{Unique: "proof-approve"}, func(c telebot.Context) error {
+		return markupProofReview(c, true)
+	})
+	b.Handle(&telebot.Btn{Unique: "proof-reject"}, func(c telebot.Context) error {
+		return markupProofReview(c, false)
+	})
 
-	admin.Handle("/update", func(c telebot.Context) error {
-		if err := update(); err != nil {
-			return err
+	b.Handle(&telebot.Btn{Unique: "challenge-join"}, markupChallengeJoin)
+
+	// buttons have a stable unique per action and keep their state in the callback data (or in a CallbackToken),
+	// so they're registered once here and keep working after a restart
+	b.Handle(&telebot.Btn{Unique: "journey-rank"}, markupNew)
+
+	b.Handle(&telebot.Btn{Unique: "relapsed"}, markupCheckRelapsed)
+	b.Handle(&telebot.Btn{Unique: "survived"}, markupCheckSurvived)
+	b.Handle(&telebot.Btn{Unique: "check-note"}, markupCheckSurvivedNote)
+	b.Handle(&telebot.Btn{Unique: "entry-privacy"}, markupEntryPrivacy)
+
+	b.Handle(&telebot.Btn{Unique: "profile-entries"}, profileEntries)
+	b.Handle(&telebot.Btn{Unique: "profile-back"}, markupProfileBack)
+
+	b.Handle(&telebot.Btn{Unique: "account-score"}, markupAccountScore)
+	b.Handle(&telebot.Btn{Unique: "account-rank"}, markupAccountRank)
+	b.Handle(&telebot.Btn{Unique: "account-rank-set"}, markupAccountRankSet)
+	b.Handle(&telebot.Btn{Unique: "account-back"}, commandAccount)
+	b.Handle(&telebot.Btn{Unique: "account-activity"}, markupAccountActivity)
+	b.Handle(&telebot.Btn{Unique: "account-download"}, markupAccountDownload)
+
+	b.Handle(&telebot.Btn{Unique: "habit-done"}, markupHabitDone)
+	b.Handle(&telebot.Btn{Unique: "habit-new"}, habitNew)
+	b.Handle(&telebot.Btn{Unique: "habit-archive"}, markupHabitArchive)
+	b.Handle(&telebot.Btn{Unique: "habits-archive"}, habitsArchive)
+	b.Handle(&telebot.Btn{Unique: "habits"}, habits)
+
+	b.Handle(&telebot.Btn{Unique: "motivation-send"}, func(c telebot.Context) error {
+		name, ok := callbackValue(c)
+		if !ok {
+			return c.Send(localeText(c, "err-button"))
 		}
 
-		return c.Send(lt.Text(c, "admin-update"))
-	})
-
-	admin.Handle("/change", func(c telebot.Context) error {
-		msg, action, err := i.Listen(&cauliflower.ListenOptions{
-			Context: c,
-			Message: lt.Text(c, "admin-change-ask-action"),
-		})
-		if err != nil {
-			return nil
+		m, ok := pickMotivation(c.Sender().ID, name)
+		if !ok {
+			return c.Send(localeText(c, "motivation-not-found", name))
 		}
 
-		msg, value, err := i.Listen(&cauliflower.ListenOptions{
-			Context: c,
-			Message: lt.Text(c, "admin-change-ask-value"),
-			Edit:    msg,
-		})
+		return sendMotivation(c, m)
+	})
+	b.Handle(&telebot.Btn{Unique: "motivation-favorite"}, markupMotivationFavorite)
+	b.Handle(&telebot.Btn{Unique: "motivation-dislike"}, markupMotivationDislike)
+	b.Handle(&telebot.Btn{Unique: "motivation-favorites"}, func(c telebot.Context) error {
+		page, err := strconv.Atoi(c.Callback().Data)
 		if err != nil {
-			return nil
+			return c.Send(localeText(c, "err-button"))
 		}
 
-		switch action.Text {
-		//case "nofap-channel":
-		//	lt.Config.v.Set("channels.bot", value.Text)
-//
-		//case "personal-channel":
-		//	lt.Config.v.Set("channels.personal", value.Text)
-
-		case "add-owner":
-			owner, err := strconv.ParseInt(value.Text, 10, 64)
-
-			if err != nil {
-				c.Send(err)
-				return err
-			}
-
-			owners = append(owners, owner)
-
-		case "remove-owner":
-			owner, err := strconv.ParseInt(value.Text, 10, 64)
+		return motivationFavorites(c, page)
+	})
 
-			if err != nil {
-				c.Send(err)
-				return err
-			}
+	admin := b.Group()
 
-			removeSlice(owners, owner)
+	admin.Use(ownersOnly)
 
-		default:
-			_, err = b.Edit(msg, lt.Text(c, "admin-change-failed", map[string]any{
-				"Action": action.Text,
-				"Value": value.Text,
-			}))
+	admin.Handle("/update", func(c telebot.Context) error {
+		if err := update(); err != nil {
 			return err
 		}
 
-		_, err = b.Edit(msg, lt.Text(c, "admin-change-success", map[string]any{
-			"Action": action.Text,
-			"Value": value.Text,
-		}))
-		return err
+		return c.Send(localeText(c, "admin-update"))
 	})
 
-	admin.Handle("/add-task", func(c telebot.Context) error {
-		points, err := strconv.Atoi(c.Args()[0])
-		if err != nil {
-			c.Send(lt.Text(c, "admin-error-convert-atoi", c.Args()[0]))
+	admin.Handle("/config", adminConfig)
+	admin.Handle("/reload", adminReload)
+
+	admin.Handle("/add-task", adminAddTask)
+	admin.Handle("/tasks", adminTasks)
+	admin.Handle("/add-challenge", adminAddChallenge)
+	admin.Handle("/points", adminPoints)
+	admin.Handle("/errors", adminErrors)
+	admin.Handle("/stats", adminStats)
+	admin.Handle("/add-rank", adminAddRank)
+	admin.Handle(&telebot.Btn{Unique: "admin-task-toggle"}, func(c telebot.Context) error {
+		var taskData TaskData
+		if r := db.First(&taskData, c.Callback().Data); errors.Is(r.Error, gorm.ErrRecordNotFound) {
+			return c.Send(localeText(c, "err-button"))
 		}
 
-		db.Create(&TaskData{
-			Points: points,
-			Task: c.Args()[1],
-		})
+		db.Model(&taskData).Update("is_enabled", !taskData.IsEnabled)
 
-		return c.Send(lt.Text(c, "admin-update"))
+		return adminTasks(c)
 	})
 
-	admin.Handle("/send", func(c telebot.Context) error {
-		msg, answer, err := i.Listen(&cauliflower.ListenOptions{
-			Context: c,
-			Message: "Enter the message to send",
-		})
-		if err != nil {
-			return err
-		}
-
-		var users []User
-		db.Find(&users)
-
-		for _, u := range users {
-			_, err = b.Send(u, answer.Text)
-			if err != nil {
-				c.Send("error with id: " + strconv.FormatInt(u.ID, 10))
-			}
-		}
-
-		_, err = b.Edit(msg, "done")
-		return err
+	admin.Handle("/broadcast", adminBroadcast)
+	admin.Handle("/send", adminBroadcast)
+	admin.Handle("/broadcasts", adminBroadcasts)
+	admin.Handle(&telebot.Btn{Unique: "broadcast-confirm"}, func(c telebot.Context) error {
+		return markupBroadcast(c, "scheduled")
+	})
+	admin.Handle(&telebot.Btn{Unique: "broadcast-cancel"}, func(c telebot.Context) error {
+		return markupBroadcast(c, "canceled")
 	})
 
 	admin.Handle("/dummy", func(c telebot.Context) error {
@@ -314,11 +379,106 @@ func main() {
 			TaskID:       1,
 			Text:         "abc",
 			IsDone:       false,
+			Status:       "pending",
 		})
 
 		return c.Send("done")
 	})
 
+	go func() {
+		for range time.Tick(time.Minute) {
+			runJob("subscriptions", sendSubscriptions)
+		}
+	}()
+
+	go func() {
+		for range time.Tick(time.Minute) {
+			runJob("expire", func() {
+				expireTasks()
+				expireConversations()
+			})
+		}
+	}()
+
+	go func() {
+		for range time.Tick(15 * time.Minute) {
+			runJob("challenges", updateChallenges)
+		}
+	}()
+
+	go func() {
+		for range time.Tick(time.Hour) {
+			runJob("rank-ups", notifyRankUps)
+		}
+	}()
+
+	go func() {
+		runJob("users", expireUsers)
+
+		for range time.Tick(time.Hour) {
+			runJob("users", expireUsers)
+		}
+	}()
+
+	go func() {
+		for range time.Tick(24 * time.Hour) {
+			db.Delete(&CallbackToken{}, "expires_at < ?", time.Now())
+		}
+	}()
+
+	go func() {
+		for range time.Tick(time.Minute) {
+			runJob("broadcasts", sendBroadcasts)
+		}
+	}()
+
+	// reload bot.yml and the locales when they change
+	go func() {
+		modified := configModTime()
+
+		for range time.Tick(10 * time.Second) {
+			if !currentLayout().Bool("reload_watch") {
+				continue
+			}
+
+			if t := configModTime(); t.After(modified) {
+				modified = t
+
+				runJob("reload", func() {
+					if err := reload(); err != nil {
+						reportReloadError(err)
+					}
+				})
+			}
+		}
+	}()
+
+	// the interval is read again after each digest, it can change with /reload or /config
+	go func() {
+		interval := func() time.Duration {
+			if d := configDuration("error_digest_interval"); d > 0 {
+				return d
+			}
+
+			return time.Hour
+		}
+
+		ticker := time.NewTicker(interval())
+		for range ticker.C {
+			runJob("errors", sendErrorsDigest)
+			ticker.Reset(interval())
+		}
+	}()
+
+	// backfill the score ledger now, then add the days survived every hour
+	go func() {
+		runJob("scores", rebuildAllScores)
+
+		for range time.Tick(time.Hour) {
+			runJob("scores", addJourneyDays)
+		}
+	}()
+
 	log.Println("starting bot")
 	b.Start()
 }
@@ -327,7 +487,7 @@ func profile(c telebot.Context, user User) error {
 	var j Journey
 	if r := db.First(&j, "user_id = ?", user.ID); errors.Is(r.Error, gorm.ErrRecordNotFound) {
 		// user doesn't have journeys
-		return c.Send(lt.Text(c, "profile-text-no-journey"))
+		return c.Send(localeText(c, "profile-text-no-journey"))
 	}
 
 	var translationKey string
@@ -337,7 +497,7 @@ func profile(c telebot.Context, user User) error {
 		translationKey = "profile-last-journey"
 	}
 
-	journeyIsCurrent := lt.Text(c, translationKey)
+	journeyIsCurrent := localeText(c, translationKey)
 
 	var a []Journey
 	db.Select("start").Find(&a, &Journey{
@@ -368,12 +528,22 @@ func profile(c telebot.Context, user User) error {
 	days := int(time.Now().Sub(j.Start).Hours() / 24)
 	totalScore, currentScore := calculateScore(user.ID, true), calculateScore(user.ID, false)
 
-	text := lt.Text(c, "profile-text", map[string]any{
+	var userBadges []UserBadge
+	db.Order("created_at").Find(&userBadges, "user_id = ?", user.ID)
+
+	var badgesNames []string
+	for _, userBadge := range userBadges {
+		if badge, ok := currentBadges()[userBadge.BadgeID]; ok {
+			badgesNames = append(badgesNames, badge.Emoji+" "+badgeName(c, badge))
+		}
+	}
+
+	text := localeText(c, "profile-text", map[string]any{
 		"Username": user.Username, // current/last journey
 		"TotalScore": totalScore,
 		"JourneyIsCurrent": journeyIsCurrent,
 		"CurrentScore": currentScore,
-		"Start": j.Start.Format("02 Jan 06"),
+		"Start": j.Start,
 		"Days": days,
 		"CurrentRank": currentRank,
 		"NextRank": nextRank,
@@ -385,41 +555,39 @@ func profile(c telebot.Context, user User) error {
 		"TotalDays": totalDays,
 		"TotalEntriesCount": totalEntriesCount,
 		"TotalTasksCount": totalTasksCount,
+		"Badges": badgesNames,
 	})
 
 	markup := b.NewMarkup()
 
-	button := markup.Data(lt.Text(c, "profile-button", user), randomString(16), strconv.FormatInt(user.ID, 10), "1")
-
-	b.Handle(&button, func(c telebot.Context) error {
-		return profileEntries(c, "public", func(c telebot.Context) error {
-			id, err := strconv.ParseInt(c.Callback().Data, 10, 64)
-			if err != nil {
-				return err
-			}
-
-			var user User
-			db.FirstOrCreate(&user, User{ID: id})
-			return profile(c, user)
-		})
-	})
+	button := markup.Data(localeText(c, "profile-button", user), "profile-entries", strconv.FormatInt(user.ID, 10), "1", "public")
 
 	markup.Inline(markup.Row(button))
 
 	return c.EditOrSend(text, markup)
 }
 
-func profileEntries(c telebot.Context, privacy string, backHandler func(c telebot.Context) error) error {
+// profileEntries shows a page of entries, the callback data is the user id, the page and the privacy
+// (public, or all and private for their own entries)
+func profileEntries(c telebot.Context) error {
 	data := strings.Split(c.Callback().Data, "|")
+	if len(data) < 3 {
+		return c.Send(localeText(c, "err-button"))
+	}
+
+	privacy := data[2]
+	if privacy != "public" && data[0] != strconv.FormatInt(c.Sender().ID, 10) {
+		return c.Send(localeText(c, "err-button"))
+	}
 
 	var user User
 	if r := db.First(&user, data[0]); errors.Is(r.Error, gorm.ErrRecordNotFound) {
-		return c.Send(lt.Text(c, "entries-no-account"))
+		return c.Send(localeText(c, "entries-no-account"))
 	}
 
 	page, err := strconv.Atoi(data[1])
 	if err != nil {
-		return c.Send(lt.Text(c, "err-button"))
+		return c.Send(localeText(c, "err-button"))
 	}
 
 	var count int64
@@ -430,20 +598,20 @@ func profileEntries(c telebot.Context, privacy string, backHandler func(c telebo
 	case "all":
 		db.Model(&Entry{UserID: user.ID}).Count(&count)
 		db.Limit(10).Offset((page-1)*10).Find(&entries, Entry{UserID: user.ID})
-		textPrivacy = lt.Text(c, "profile-entries-all")
+		textPrivacy = localeText(c, "profile-entries-all")
 	case "public":
 		db.Model(&Entry{UserID: user.ID, IsPublic: true}).Count(&count)
 		db.Limit(10).Offset((page-1)*10).Find(&entries, Entry{UserID: user.ID, IsPublic: true})
-		textPrivacy = lt.Text(c, "profile-entries-public")
+		textPrivacy = localeText(c, "profile-entries-public")
 	case "private":
 		db.Model(&Entry{UserID: user.ID, IsPublic: false}).Count(&count)
 		db.Limit(10).Offset((page-1)*10).Find(&entries, Entry{UserID: user.ID, IsPublic: false})
-		textPrivacy = lt.Text(c, "profile-entries-private")
+		textPrivacy = localeText(c, "profile-entries-private")
 	default:
 		return errors.New("error with profileEntries privacy")
 	}
 
-	text := lt.Text(c, "profile-entries", map[string]interface{}{
+	text := localeText(c, "profile-entries", map[string]interface{}{
 		"User":    user.Username,
 		"Page":    page,
 		"MaxPage": count/10 + 1,
@@ -453,92 +621,78 @@ func profileEntries(c telebot.Context, privacy string, backHandler func(c telebo
 
 	markup := b.NewMarkup()
 
-	var previous, next telebot.Btn
+	id := strconv.FormatInt(user.ID, 10)
+
+	var row telebot.Row
 
 	if page > 1 {
-		previous = markup.Data(lt.Text(c, "pagination-previous"), randomString(16), strconv.FormatInt(user.ID, 10), strconv.Itoa(page-1))
+		row = append(row, markup.Data(localeText(c, "pagination-previous"), "profile-entries", id, strconv.Itoa(page-1), privacy))
 	}
 
-	if int(count/10) > page {
-		next = markup.Data(lt.Text(c, "pagination-next"), randomString(16), strconv.FormatInt(user.ID, 10), strconv.Itoa(page+1))
+	if int64(page*10) < count {
+		row = append(row, markup.Data(localeText(c, "pagination-next"), "profile-entries", id, strconv.Itoa(page+1), privacy))
 	}
 
-	back := markup.Data(lt.Text(c, "pagination-back"), randomString(16), strconv.FormatInt(user.ID, 10))
-
-	markup.Inline(markup.Row(previous, next, back))
-
-	b.Handle(&next, func(c telebot.Context) error {
-		return profileEntries(c, "public", func(c telebot.Context) error {
-			id, err := strconv.ParseInt(c.Callback().Data, 10, 64)
-			if err != nil {
-				return err
-			}
+	row = append(row, markup.Data(localeText(c, "pagination-back"), "profile-back", id, privacy))
 
-			var user User
-			db.FirstOrCreate(&user, User{ID: id})
-			return profile(c, user)
-		})
-	})
+	markup.Inline(row)
 
-	b.Handle(&previous, func(c telebot.Context) error {
-		return profileEntries(c, "public", func(c telebot.Context) error {
-			id, err := strconv.ParseInt(c.Callback().Data, 10, 64)
-			if err != nil {
-				return err
-			}
+	return c.Edit(text, markup)
+}
 
-			var user User
-			db.FirstOrCreate(&user, User{ID: id})
-			return profile(c, user)
-		})
-	})
+// markupProfileBack goes back to the profile of the user for public entries, or to the account
+func markupProfileBack(c telebot.Context) error {
+	data := strings.Split(c.Callback().Data, "|")
+	if len(data) < 2 || data[1] != "public" {
+		return commandAccount(c)
+	}
 
-	b.Handle(&back, backHandler)
+	id, err := strconv.ParseInt(data[0], 10, 64)
+	if err != nil {
+		return c.Send(localeText(c, "err-button"))
+	}
 
-	return c.Edit(text, markup)
+	var user User
+	db.FirstOrCreate(&user, User{ID: id})
+	return profile(c, user)
 }
 
 func commandStart(c telebot.Context) error {
-	db.Save(&User{
-		ID:       c.Sender().ID,
-		Username: c.Sender().Username,
-	})
+	seeUser(c.Sender())
 
-	return c.Send(lt.Text(c, "start-hello"))
+	return c.Send(localeText(c, "start-hello"))
 }
 
 func commandNew(c telebot.Context) error {
 	var found bool
 	db.Raw("SELECT EXISTS(SELECT 1 FROM journeys WHERE user_id = ? AND end = ?) AS found", c.Sender().ID, time.Time{}).Scan(&found)
 	if found {
-		return c.Send(lt.Text(c, "new-already-running-journey"))
+		return c.Send(localeText(c, "new-already-running-journey"))
 	}
 
-	msg, answer, err := i.Listen(&cauliflower.ListenOptions{
-		Context: c,
-		Message: lt.Text(c, "new-ask-streak"),
-	})
+	msg, err := b.Send(c.Chat(), localeText(c, "new-ask-streak"))
 	if err != nil {
-		return nil
+		return err
 	}
 
-	days, err := strconv.Atoi(answer.Text)
-	if err != nil {
-		return c.Send(lt.Text(c, "new-not-a-number"))
+	startConversation(c, msg, "new", "streak")
+	return nil
+}
+
+func newStreak(c telebot.Context, conv *Conversation) error {
+	days, err := strconv.Atoi(strings.TrimSpace(c.Text()))
+	if err != nil || days < 0 {
+		return c.Send(localeText(c, "new-not-a-number"))
 	}
 
 	start := time.Now().Add(-time.Duration(days) * time.Hour * 24)
-	text := lt.Text(c, "new-ask-rank", map[string]any{
-		"Start": start.Format("02 Jan 06"),
-	})
 
-	db.Create(&Journey{
-		CreatedAtStr: time.Now().Format("02 Jan 06 15:04"),
-		UserID:       c.Sender().ID,
-		Start:        start,
-	})
+	conv.Data["start"] = start.Format(time.RFC3339)
+	setConversation(conv, "rank")
 
-	_, err = b.Edit(msg, text, ranksMarkup)
+	_, err = b.Edit(conv, localeText(c, "new-ask-rank", map[string]any{
+		"Start": start,
+	}), rankSystemsMarkup("journey-rank"))
 	return err
 }
 
@@ -546,42 +700,70 @@ func commandCheck(c telebot.Context) error {
 	var found bool
 	db.Raw("SELECT EXISTS(SELECT 1 FROM journeys WHERE user_id = ? AND end = ?) AS found", c.Sender().ID, time.Time{}).Scan(&found)
 	if !found {
-		return c.Send(lt.Text(c, "check-no-journey"))
+		return c.Send(localeText(c, "check-no-journey"))
 	}
 
 	now, midnight := today()
 
 	var count int64
-	db.Model(&Entry{}).Where("created_at BETWEEN ? AND ?", midnight, now).Count(&count)
+	db.Model(&Entry{}).Where("user_id = ? AND created_at BETWEEN ? AND ?", c.Sender().ID, midnight, now).Count(&count)
 	if int(count) >= 3 {
-		return c.Send(lt.Text(c, "check-already-checked-in"))
+		return c.Send(localeText(c, "check-already-checked-in"))
 	}
 
 	markup := b.NewMarkup()
 
-	relapsed := markup.Data(lt.Text(c, "check-button-relapsed"), "relapsed")
-	survived := markup.Data(lt.Text(c, "check-button-survived"), "survived")
-
-	b.Handle(&relapsed, markupCheckRelapsed)
-	b.Handle(&survived, markupCheckSurvived)
+	relapsed := markup.Data(localeText(c, "check-button-relapsed"), "relapsed")
+	survived := markup.Data(localeText(c, "check-button-survived"), "survived")
 
 	markup.Inline(markup.Row(relapsed, survived))
 
-	return c.Send(lt.Text(c, "check-ask-relapsed"), markup)
+	msg, err := b.Send(c.Chat(), localeText(c, "check-ask-relapsed"), markup)
+	if err != nil {
+		return err
+	}
+
+	startConversation(c, msg, "check", "status")
+	return nil
+}
+
+func commandUrge(c telebot.Context) error {
+	db.Create(&Urge{UserID: c.Sender().ID})
+
+	var count int64
+	db.Model(&Urge{}).Where("user_id = ?", c.Sender().ID).Count(&count)
+
+	if err := c.Send(localeText(c, "urge-saved", count)); err != nil {
+		return err
+	}
+
+	return awardBadges(c)
 }
 
 func commandTask(c telebot.Context) error {
+	category := ""
+
+	if len(c.Args()) > 0 {
+		category = strings.ToLower(c.Args()[0])
+		if categories := configStrings("task_categories"); !slices.Contains(categories, category) {
+			return c.Send(localeText(c, "task-unknown-category", categories))
+		}
+	}
+
 	now, midnight := today()
 
 	var count int64
-	db.Model(&Task{}).Where("user_id = ? AND updated_at BETWEEN ? AND ?", c.Sender().ID, now, midnight).Count(&count)
+	db.Model(&Task{}).Where("user_id = ? AND habit_id = 0 AND is_done = ? AND updated_at BETWEEN ? AND ?", c.Sender().ID, true, midnight, now).Count(&count)
 	if int(count) >= 3 {
-		return c.Send(lt.Text(c, "task-too-much"))
+		return c.Send(localeText(c, "task-too-much"))
 	}
 
 	var task Task
 
-	if r := db.First(&task, "user_id = ? AND is_done = ?", c.Sender().ID, false); r.RowsAffected > 0 {
+	// an overdue task doesn't block a new one, even before the expiry job
+	if r := db.First(&task, "user_id = ? AND is_done = ? AND status = ?", c.Sender().ID, false, "pending"); r.RowsAffected > 0 && !task.Deadline.IsZero() && task.Deadline.Before(time.Now()) {
+		expireTask(task)
+	} else if r.RowsAffected > 0 {
 		chat, err := b.ChatByID(task.UserID)
 		if err != nil {
 			return err
@@ -592,115 +774,263 @@ func commandTask(c telebot.Context) error {
 			Chat: chat,
 		}
 
-		_, err = b.Reply(&msg, lt.Text(c, "task-unfinished"))
+		_, err = b.Reply(&msg, localeText(c, "task-unfinished"))
 		return err
 	}
 
-	var taskData TaskData
-	db.Order("RANDOM()").Take(&taskData)
-	taskText := lt.Text(c, taskData.Task)
-
-	text := lt.Text(c, "task-cta",map[string]any{
-		"Task": taskText,
-		"Now": time.Now().Format("02 Jan 06 15:04"),
-	})
-
-	markup := b.NewMarkup()
+	taskData, ok := pickTaskData(category, 0)
+	if !ok {
+		return c.Send(localeText(c, "task-none"))
+	}
 
-	button := markup.Data(lt.Text(c, "task-button"), randomString(16))
+	// the task can be done until the end of the user's day
+	local := now.In(userLocation(c.Sender().ID))
 
-	b.Handle(&button, markupTaskDone)
+	task = Task{
+		CreatedAtStr: time.Now().Format("02 Jan 06 15:04"),
+		UserID:       c.Sender().ID,
+		ChatID:       c.Chat().ID,
+		TaskID:       int(taskData.ID),
+		Text:         taskDataText(c, taskData),
+		IsDone:       false,
+		Category:     category,
+		Status:       "pending",
+		Deadline:     time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, local.Location()),
+	}
 
-	markup.Inline(markup.Row(button))
+	// the buttons need the task id, so it's removed if the message can't be sent
+	db.Create(&task)
 
-	msg, err := b.Send(c.Chat(), text, markup)
+	msg, err := b.Send(c.Chat(), taskText(c, task, taskData), taskMarkup(c, task))
 	if err != nil {
+		db.Unscoped().Delete(&task)
 		return err
 	}
 
-	db.Create(&Task{
-		CreatedAtStr: time.Now().Format("02 Jan 06 15:04"),
-		UserID:       c.Sender().ID,
-		MessageID:    msg.ID,
-		TaskID:       int(taskData.ID),
-		Text:         taskText,
-		IsDone:       false,
-	})
+	db.Model(&task).Update("message_id", msg.ID)
 
 	return nil
 }
 
-func commandMotivation(c telebot.Context) error {
-	if len(c.Args()) == 0 {
-		var m Motivation
-		db.Order("RANDOM()").Take(&m)
-
-		if m.Pack != "" {
-			return sendPack(c, m)
-		}
+// pickTaskData returns a random enabled task of the category (any if empty), different from exclude
+func pickTaskData(category string, exclude uint) (TaskData, bool) {
+	var taskData TaskData
 
-		return c.Send(&telebot.Photo{
-			File:    telebot.FromDisk(m.Path),
-			Caption: lt.Text(c, "motivation-caption", m),
-		})
+	query := db.Preload("Texts").Where("is_enabled = ? AND id <> ?", true, exclude)
+	if category != "" {
+		query = query.Where("category = ?", category)
 	}
 
-	arg := c.Args()[0]
-
-	if arg == "list" {
-		return c.Send(lt.Text(c, "motivation-list", motivationsCategories))
-	}
+	r := query.Order("RANDOM()").Take(&taskData)
 
-	c.Notify(telebot.UploadingPhoto)
+	return taskData, r.RowsAffected > 0
+}
 
-	var m Motivation
+func taskDataText(c telebot.Context, taskData TaskData) string {
+	locale := userLocale(c.Sender())
 
-	if r := db.Where("pack = ? OR id = ? OR category = ?", arg, arg, arg).Order("RANDOM()").Take(&m); r.RowsAffected == 0 {
-		return c.Send(lt.Text(c, "motivation-error", cm.Closest(arg)))
+	if text := taskData.Text(locale); text != "" {
+		return text
 	}
 
-	if m.Pack != "" {
-		return sendPack(c, m)
-	}
+	return localeText(c, taskData.Task)
+}
 
-	return c.Send(&telebot.Photo{
-		File:    telebot.FromDisk(m.Path),
-		Caption: lt.Text(c, "motivation-caption", m),
+func taskText(c telebot.Context, task Task, taskData TaskData) string {
+	return localeText(c, "task-cta",map[string]any{
+		"Task": task.Text,
+		"Now": task.CreatedAt,
+		"Deadline": task.Deadline,
+		"Category": localeText(c, "task-category-" + taskData.Category),
+		"Difficulty": strings.Repeat("⭐", taskData.Difficulty),
+		"Duration": taskData.Duration,
 	})
 }
 
-func commandProfile(c telebot.Context) error {
-	var user User
+func taskMarkup(c telebot.Context, task Task) *telebot.ReplyMarkup {
+	markup := b.NewMarkup()
 
-	if len(c.Args()) > 0 {
-		username := strings.Trim(c.Args()[0], "@")
+	id := strconv.Itoa(int(task.ID))
 
-		if r := db.Last(&user, "username = ?", username); errors.Is(r.Error, gorm.ErrRecordNotFound) {
-			// user doesn't exist
-			return c.Send(lt.Text(c, "profile-text-no-journey"))
-		}
-	} else {
-		user.ID, user.Username = c.Sender().ID, c.Sender().Username
-	}
+	markup.Inline(
+		markup.Row(markup.Data(localeText(c, "task-button"), "task-done", id)),
+		markup.Row(
+			markup.Data(localeText(c, "task-button-skip"), "task-skip", id),
+			markup.Data(localeText(c, "task-button-reroll"), "task-reroll", id),
+		),
+	)
 
-	return profile(c, user)
+	return markup
 }
 
-func commandAccount(c telebot.Context) error {
-	var text string
+// expireTasks marks the pending tasks past their deadline as expired and removes their buttons
+func expireTasks() {
+	var tasks []Task
+	db.Where("is_done = ? AND status = ? AND deadline <> ? AND deadline < ?", false, "pending", time.Time{}, time.Now()).Find(&tasks)
 
-	var j Journey
-	if r := db.First(&j, "user_id = ?", c.Sender().ID); errors.Is(r.Error, gorm.ErrRecordNotFound) {
-		// user doesn't have journeys
-		return c.Send(lt.Text(c, "account-text-no-journey"))
+	for _, task := range tasks {
+		expireTask(task)
 	}
+}
 
-	var a []Journey
-	db.Select("start").Find(&a, &Journey{
-		UserID: c.Sender().ID,
-	})
+// expireTask marks the task as expired and removes its buttons, unless it isn't pending anymore
+// (the job and /task can both expire it)
+func expireTask(task Task) {
+	if r := db.Model(&Task{}).Where("id = ? AND status = ?", task.ID, "pending").Update("status", "expired"); r.RowsAffected == 0 {
+		return
+	}
 
-	var totalDays int
+	chatID := task.ChatID
+	if chatID == 0 {
+		chatID = task.UserID
+	}
+
+	asUser(task.UserID, func(c telebot.Context) error {
+		_, err := b.Edit(&telebot.StoredMessage{
+			MessageID: strconv.Itoa(task.MessageID),
+			ChatID:    chatID,
+		}, localeText(c, "task-expired", task))
+		return err
+	})
+}
+
+func commandMotivation(c telebot.Context) error {
+	if len(c.Args()) == 0 {
+		m, _ := pickMotivation(c.Sender().ID, "")
+
+		return sendMotivation(c, m)
+	}
+
+	arg := c.Args()[0]
+
+	switch arg {
+	case "list":
+		return c.Send(localeText(c, "motivation-list", motivationsCategories))
+	case "favorites":
+		return motivationFavorites(c, 1)
+	case "subscribe":
+		return motivationSubscribe(c, c.Args()[1:])
+	case "unsubscribe":
+		return motivationUnsubscribe(c)
+	case "pause":
+		return motivationPause(c)
+	case "search":
+		return motivationSearch(c, strings.Join(c.Args()[1:], " "))
+	}
+
+	m, ok := pickMotivation(c.Sender().ID, arg)
+	if !ok {
+		return motivationSuggestions(c, arg)
+	}
+
+	switch m.Type {
+	case "photo":
+		c.Notify(telebot.UploadingPhoto)
+	case "video", "animation":
+		c.Notify(telebot.UploadingVideo)
+	default:
+		c.Notify(telebot.Typing)
+	}
+
+	return sendMotivation(c, m)
+}
+
+// commandInline answers inline queries (@bot query) with the streak of the user
+// and cached motivations matching the query
+func commandInline(c telebot.Context) error {
+	var results telebot.Results
+
+	var j Journey
+	if r := db.Where("user_id = ? AND end = ?", c.Sender().ID, time.Time{}).Last(&j); r.RowsAffected > 0 {
+		_, rank := getRank(j.Start, j.RankSystem, 0)
+
+		text := localeText(c, "inline-streak-text", map[string]any{
+			"Username": c.Sender().Username,
+			"Days":     int(time.Now().Sub(j.Start).Hours() / 24),
+			"Rank":     rank,
+		})
+
+		results = append(results, &telebot.ArticleResult{
+			ResultBase:  telebot.ResultBase{ID: "streak"},
+			Title:       localeText(c, "inline-streak-title"),
+			Description: text,
+			Text:        text,
+		})
+	}
+
+	// only motivations already sent once have a telegram file id, texts don't need one
+	query := db.Where("(file_id <> ? OR type IN ?)", "", []string{"text", "markdown"})
+
+	if arg := strings.TrimSpace(c.Query().Text); arg != "" {
+		match := cm.Closest(arg)
+		query = query.Where("(pack IN ? OR id IN ? OR category IN ?)", []string{arg, match}, []string{arg, match}, []string{arg, match})
+	}
+
+	var motivations []Motivation
+	query.Order("RANDOM()").Limit(49).Find(&motivations)
+
+	for _, m := range motivations {
+		caption := localeText(c, "motivation-caption", m)
+		base := telebot.ResultBase{ID: m.UUID}
+
+		switch m.Type {
+		case "video":
+			results = append(results, &telebot.VideoResult{ResultBase: base, Cache: m.FileID, Title: caption, Caption: caption})
+		case "animation":
+			results = append(results, &telebot.GifResult{ResultBase: base, Cache: m.FileID, Caption: caption})
+		case "text", "markdown":
+			media, err := motivationMedia(m, caption)
+			if err != nil {
+				continue
+			}
+
+			// the text is markdown like the messages of the bot (escaped for plain texts)
+			base.Content = &telebot.InputTextMessageContent{Text: media.(string), ParseMode: telebot.ModeMarkdown}
+			results = append(results, &telebot.ArticleResult{ResultBase: base, Title: caption})
+		default:
+			results = append(results, &telebot.PhotoResult{ResultBase: base, Cache: m.FileID, Caption: caption})
+		}
+	}
+
+	return c.Answer(&telebot.QueryResponse{
+		Results:    results,
+		CacheTime:  60,
+		IsPersonal: true,
+	})
+}
+
+func commandProfile(c telebot.Context) error {
+	var user User
+
+	if len(c.Args()) > 0 {
+		username := strings.Trim(c.Args()[0], "@")
+
+		if r := db.Last(&user, "username = ?", username); errors.Is(r.Error, gorm.ErrRecordNotFound) {
+			// user doesn't exist
+			return c.Send(localeText(c, "profile-text-no-journey"))
+		}
+	} else {
+		user.ID, user.Username = c.Sender().ID, c.Sender().Username
+	}
+
+	return profile(c, user)
+}
+
+func commandAccount(c telebot.Context) error {
+	var text string
+
+	var j Journey
+	if r := db.First(&j, "user_id = ?", c.Sender().ID); errors.Is(r.Error, gorm.ErrRecordNotFound) {
+		// user doesn't have journeys
+		return c.Send(localeText(c, "account-text-no-journey"))
+	}
+
+	var a []Journey
+	db.Select("start").Find(&a, &Journey{
+		UserID: c.Sender().ID,
+	})
+
+	var totalDays int
 	for _, j := range a {
 		totalDays += int(time.Now().Sub(j.Start).Hours() / 24)
 	}
@@ -718,8 +1048,8 @@ func commandAccount(c telebot.Context) error {
 	_, currentRank := getRank(j.Start, j.RankSystem, 0)
 	daysLeft, nextRank := getRank(j.Start, j.RankSystem, 1)
 
-	text = lt.Text(c, "account-text", map[string]any{
-		"Score": calculateScore(c.Sender().ID, true),
+	text = localeText(c, "account-text", map[string]any{
+		"Score": scoreBreakdown(c.Sender().ID, true),
 		"CurrentRank": currentRank,
 		"NextRank": nextRank,
 		"DaysLeft": daysLeft,
@@ -731,18 +1061,15 @@ func commandAccount(c telebot.Context) error {
 
 	markup := b.NewMarkup()
 
-	activity := markup.Data(lt.Text(c, "account-activity"), randomString(16))
-	entries := markup.Data(lt.Text(c, "account-entries"), randomString(16), strconv.FormatInt(c.Sender().ID, 10), "1")
-	download := markup.Data(lt.Text(c, "account-download"), randomString(16))
-
-	b.Handle(&activity, markupAccountActivity)
-	b.Handle(&download, markupAccountDownload)
-	b.Handle(&entries, func(c telebot.Context) error {
-		return profileEntries(c, "all", commandAccount)
-	})
+	score := markup.Data(localeText(c, "account-score"), "account-score")
+	rank := markup.Data(localeText(c, "account-rank"), "account-rank")
+	activity := markup.Data(localeText(c, "account-activity"), "account-activity")
+	entries := markup.Data(localeText(c, "account-entries"), "profile-entries", strconv.FormatInt(c.Sender().ID, 10), "1", "all")
+	download := markup.Data(localeText(c, "account-download"), "account-download")
 
 	markup.Inline(
 		markup.Row(activity, entries),
+		markup.Row(score, rank),
 		markup.Row(download),
 	)
 
@@ -752,32 +1079,49 @@ func commandAccount(c telebot.Context) error {
 func commandRanks(c telebot.Context) error {
 	var text string
 
+	query := db.Preload("Levels", func(db *gorm.DB) *gorm.DB {
+		return db.Order("days")
+	}).Order("id")
+
 	if len(c.Args()) > 0 {
-		rank := ranks[c.Args()[0]]
+		query = query.Where("key = ?", strings.ToLower(c.Args()[0]))
+	}
 
-		text += "*" + rank.Name + "*\n"
+	var systems []RankSystem
+	query.Find(&systems)
 
-		sortedLevels := maps.Keys(rank.Levels)
-		sort.Ints(sortedLevels)
+	for _, system := range systems {
+		text += "*" + system.Name + "* (`" + system.Key + "`"
+		if system.Score != 0 {
+			text += ", +" + strconv.Itoa(system.Score) + "%"
+		}
+		text += ")\n"
 
-		for _, level := range sortedLevels {
-			text += strconv.Itoa(level) + ": " + rank.Levels[level] + "\n"
+		levels := system.Levels
+		if len(c.Args()) == 0 && len(levels) > 3 {
+			levels = levels[:3]
 		}
-	} else {
-		for _, rank := range ranks {
-			text += "*" + rank.Name + "*\n"
 
-			sortedLevels := maps.Keys(rank.Levels)
-			sort.Ints(sortedLevels)
+		for _, level := range levels {
+			text += strconv.Itoa(level.Days) + ": " + strings.TrimSpace(level.Emoji+" "+level.Name)
 
-			for _, level := range sortedLevels[:3] {
-				text += strconv.Itoa(level) + ": " + rank.Levels[level] + "\n"
+			// descriptions only in the full list
+			if len(c.Args()) > 0 && level.Description != "" {
+				text += " - _" + level.Description + "_"
 			}
 
+			text += "\n"
+		}
+
+		if len(c.Args()) == 0 {
 			text += "...\n\n"
 		}
 	}
 
+	if text == "" {
+		return c.Send(localeText(c, "ranks-unknown"))
+	}
+
 	return c.Send(text)
 }
 
@@ -792,352 +1136,3574 @@ func commandHelp(c telebot.Context) error {
 
 	averageResponseTime := totalResponseTime / time.Duration(len(responseTime))
 
-	return c.Send(lt.Text(c, "help-text", map[string]any{
+	return c.Send(localeText(c, "help-text", map[string]any{
 		"UsersCount": users,
 		"MessageCount": messageCount,
 		"AverageResponseTime": averageResponseTime,
-		"Uptime": start.Format("02 Jan 06 15:04"),
-		"NofapChannel": lt.String("channels.bot"),
-		"PersonalChannel": lt.String("channels.personal"),
+		"Uptime": start,
+		"NofapChannel": configString("channels.bot"),
+		"PersonalChannel": configString("channels.personal"),
 	}))
 }
 
-func commandFix(c telebot.Context) error {
-	db.Save(&User{
-		ID:       c.Sender().ID,
-		Username: c.Sender().Username,
-	})
+// commandLanguage sends the current language and a button per locale file
+func commandLanguage(c telebot.Context) error {
+	markup := b.NewMarkup()
+
+	// the locales come from a map, sorted so the buttons don't move
+	locales := currentLayout().Locales()
+	sort.Strings(locales)
 
-	return c.Send(lt.Text(c, "fix-text"))
+	var buttons []telebot.Btn
+	for _, locale := range locales {
+		buttons = append(buttons, markup.Data(currentLayout().TextLocale(locale, "language-name"), "language-set", locale))
+	}
+
+	buttons = append(buttons, markup.Data(localeText(c, "language-button-auto"), "language-set", "auto"))
+
+	markup.Inline(markup.Split(2, buttons)...)
+
+	var user User
+	db.First(&user, c.Sender().ID)
+
+	return c.Send(localeText(c, "language-text", map[string]any{
+		"Language": localeText(c, "language-name"),
+		"Auto":     user.Language == "",
+	}), markup)
 }
 
-func markupNew(c telebot.Context) error {
-	var j Journey
+// markupLanguageSet saves the chosen language, auto follows the telegram language again
+func markupLanguageSet(c telebot.Context) error {
+	language := c.Callback().Data
+	if language == "auto" {
+		language = ""
+	} else if !slices.Contains(currentLayout().Locales(), language) {
+		return c.Edit(localeText(c, "err-button"))
+	}
 
-	db.Model(&j).Where("user_id = ? AND end = ?", c.Sender().ID, time.Time{}).Updates(Journey{RankSystem: c.Callback().Data}).First(&j)
+	db.Model(&User{ID: c.Sender().ID}).Update("language", language)
 
-	_, rank := getRank(j.Start, j.RankSystem, 0)
+	languageMutex.Lock()
+	delete(usersLanguage, c.Sender().ID)
+	languageMutex.Unlock()
 
-	return c.Edit(lt.Text(c, "new-saved", map[string]any{
-		"Rank": rank,
-		"RankSystem": j.RankSystem,
-		"Start": j.Start.Format("02 Jan 06"),
-		"Days": int(time.Now().Sub(j.Start).Hours()/24),
-	}))
+	return c.Edit(localeText(c, "language-changed", localeText(c, "language-name")))
 }
 
-func markupCheckRelapsed(c telebot.Context) error {
+func commandFix(c telebot.Context) error {
+	seeUser(c.Sender())
+
+	return c.Send(localeText(c, "fix-text"))
+}
+
+// adminAddTask asks the category, difficulty, duration, points and the text in every language of a new task
+func adminAddTask(c telebot.Context) error {
 	msg, answer, err := i.Listen(&cauliflower.ListenOptions{
 		Context: c,
-		Message: lt.Text(c, "relapsed"),
-		Edit:    c.Message(),
+		Message: localeText(c, "admin-task-ask-category", configStrings("task_categories")),
 	})
 	if err != nil {
 		return nil
 	}
 
-	db.Where("user_id = ?", c.Sender().ID).Updates(&Journey{
-		End:  time.Now(),
-		Text: answer.Text,
-	})
+	category := strings.ToLower(strings.TrimSpace(answer.Text))
+	if categories := configStrings("task_categories"); !slices.Contains(categories, category) {
+		_, err = b.Edit(msg, localeText(c, "task-unknown-category", categories))
+		return err
+	}
 
-	_, err = b.Edit(msg, lt.Text(c, "relapsed-saved"))
-	return err
-}
+	// difficulty, duration and points are numbers in a range
+	numbers := []struct {
+		Key      string
+		Min, Max int
+		Value    int
+	}{
+		{Key: "admin-task-ask-difficulty", Min: 1, Max: 3},
+		{Key: "admin-task-ask-duration", Min: 1, Max: 240},
+		{Key: "admin-task-ask-points", Min: 2, Max: 10},
+	}
 
-func markupCheckSurvived(c telebot.Context) error {
-	return c.Edit(lt.Text(c, "survived-ask-note"), notesMarkup)
-}
+	for n := range numbers {
+		msg, answer, err = i.Listen(&cauliflower.ListenOptions{
+			Context: c,
+			Message: localeText(c, numbers[n].Key, numbers[n]),
+			Edit:    msg,
+		})
+		if err != nil {
+			return nil
+		}
 
-func markupCheckSurvivedNote(c telebot.Context) error {
-	data := strings.TrimSpace(c.Callback().Data)
+		number, err := strconv.Atoi(strings.TrimSpace(answer.Text))
+		if err != nil || number < numbers[n].Min || number > numbers[n].Max {
+			_, err = b.Edit(msg, localeText(c, "admin-task-invalid-number", numbers[n]))
+			return err
+		}
 
-	number, err := strconv.Atoi(data)
-	if err != nil {
-		return err
+		numbers[n].Value = number
 	}
 
-	msg, answer, err := i.Listen(&cauliflower.ListenOptions{
+	msg, answer, err = i.Listen(&cauliflower.ListenOptions{
 		Context: c,
-		Message: lt.Text(c, "survived-ask-entry"),
-		Edit:    c.Message(),
+		Message: localeText(c, "admin-task-ask-proof"),
+		Edit:    msg,
 	})
 	if err != nil {
 		return nil
 	}
 
-	db.Create(&Entry{
-		CreatedAtStr: time.Now().Format("02 Jan 06 15:04"),
-		UserID:       c.Sender().ID,
-		IsPublic:     false,
-		Note:         number,
-		Text:         answer.Text,
-	})
+	requiresProof := strings.EqualFold(strings.TrimSpace(answer.Text), localeText(c, "admin-task-yes"))
 
-	markup := b.NewMarkup()
+	var texts []TaskText
 
-	public := markup.Data(lt.Text(c, "survived-button-public"), "public")
-	private := markup.Data(lt.Text(c, "survived-button-private"), "private")
+	locales := currentLayout().Locales()
+	sort.Strings(locales)
 
-	b.Handle(&public, func(c telebot.Context) error {
-		return handlePrivacy(c, true, number, answer.Text)
-	})
+	for _, locale := range locales {
+		msg, answer, err = i.Listen(&cauliflower.ListenOptions{
+			Context: c,
+			Message: localeText(c, "admin-task-ask-text", locale),
+			Edit:    msg,
+		})
+		if err != nil {
+			return nil
+		}
 
-	b.Handle(&private, func(c telebot.Context) error {
-		return handlePrivacy(c, false, number, answer.Text)
-	})
+		if text := strings.TrimSpace(answer.Text); text != "-" {
+			texts = append(texts, TaskText{Language: locale, Text: text})
+		}
+	}
 
-	markup.Inline(markup.Row(public, private))
+	if len(texts) == 0 {
+		_, err = b.Edit(msg, localeText(c, "admin-task-no-text"))
+		return err
+	}
 
-	_, err = b.Edit(msg, lt.Text(c, "survived-ask-public"), markup)
+	taskData := TaskData{
+		Category:   category,
+		Difficulty: numbers[0].Value,
+		Duration:   numbers[1].Value,
+		Points:     numbers[2].Value,
+		IsEnabled:  true,
+		RequiresProof: requiresProof,
+		Texts:      texts,
+	}
+
+	db.Create(&taskData)
+
+	_, err = b.Edit(msg, localeText(c, "admin-task-created", taskData))
 	return err
 }
 
-func markupTaskDone(c telebot.Context) error {
-	var task Task
-
-	if r := db.First(&task, "user_id = ? AND is_done = ?", c.Sender().ID, false); r.RowsAffected > 0 {
-		if r := db.Model(&task).Updates(Task{IsDone: true}); r.Error != nil {
-			log.Println(r.Error)
-		}
+// adminTasks lists every task with a button to enable/disable it
+func adminTasks(c telebot.Context) error {
+	var tasks []TaskData
+	db.Preload("Texts").Order("category, id").Find(&tasks)
 
-		var taskData TaskData
-		db.First(&taskData, task.TaskID)
+	markup := b.NewMarkup()
 
-		text := lt.Text(c, "task-done", map[string]any{
-			"Task": task.Text,
-			"GivenAt": task.CreatedAt.Format("02 Jan 06 15:04"),
-			"DoneAt": task.UpdatedAt.Format("02 Jan 06 15:04"),
-			"Points": taskData.Points,
-		})
+	var buttons []telebot.Btn
+	for _, t := range tasks {
+		state := "❌"
+		if t.IsEnabled {
+			state = "✅"
+		}
 
-		return c.Edit(text)
+		buttons = append(buttons, markup.Data(state+" #"+strconv.Itoa(int(t.ID)), "admin-task-toggle", strconv.Itoa(int(t.ID))))
 	}
 
-	return nil
-}
-
-func markupAccountActivity(c telebot.Context) error {
-	var journeys []Journey
-	var entries []Entry
-	var tasks []Task
+	markup.Inline(markup.Split(4, buttons)...)
 
-	db.Find(&journeys, "user_id = ?", c.Sender().ID)
-	db.Find(&entries, "user_id = ?", c.Sender().ID)
-	db.Find(&tasks, "user_id = ?", c.Sender().ID)
+	locale := userLocale(c.Sender())
 
-	var activities []Activity
+	return c.EditOrSend(localeText(c, "admin-tasks", map[string]any{
+		"Tasks":  tasks,
+		"Locale": locale,
+	}), markup)
+}
 
-	for _, j := range journeys {
-		activities = append(activities, Activity{CreatedAt: j.CreatedAt, Type: "journey", Item: j})
+func commandHabits(c telebot.Context) error {
+	if len(c.Args()) > 0 {
+		switch c.Args()[0] {
+		case "new":
+			return habitNew(c)
+		case "archive":
+			return habitsArchive(c)
+		}
 	}
 
-	for _, e := range entries {
-		activities = append(activities, Activity{CreatedAt: e.CreatedAt, Type: "entry", Item: e})
-	}
+	return habits(c)
+}
 
-	for _, t := range tasks {
-		activities = append(activities, Activity{CreatedAt: t.CreatedAt, Type: "task", Item: t})
-	}
+// habits lists the active habits of the user with their progress, streak and a button to complete them
+func habits(c telebot.Context) error {
+	var userHabits []Habit
+	db.Where("user_id = ? AND is_archived = ?", c.Sender().ID, false).Order("id").Find(&userHabits)
 
-	sort.SliceStable(activities, func(i, j int) bool {
-		return activities[i].CreatedAt.Before(activities[j].CreatedAt)
-	})
+	type habitView struct {
+		Habit
+		Done   int
+		Streak int
+	}
 
 	markup := b.NewMarkup()
 
-	back := markup.Data(lt.Text(c, "pagination-back"), randomString(16))
+	var views []habitView
+	var rows []telebot.Row
 
-	b.Handle(&back, commandAccount)
+	for _, h := range userHabits {
+		done, streak := habitProgress(h)
+		views = append(views, habitView{Habit: h, Done: done, Streak: streak})
 
-	markup.Inline(markup.Row(back))
+		if done < h.Target() && !habitDoneToday(h) {
+			rows = append(rows, markup.Row(markup.Data("✅ "+h.Name, "habit-done", strconv.Itoa(int(h.ID)))))
+		}
+	}
 
-	return c.Edit(lt.Text(c, "account-activity-text", activities), markup)
-}
+	rows = append(rows, markup.Row(
+		markup.Data(localeText(c, "habits-button-new"), "habit-new"),
+		markup.Data(localeText(c, "habits-button-archive"), "habits-archive"),
+	))
 
-func markupAccountDownload(c telebot.Context) error {
-	c.Notify(telebot.UploadingDocument)
+	markup.Inline(rows...)
 
-	var activities []Activity
-	var journeys []Journey
-	var entries []Entry
-	var tasks []Task
+	return c.EditOrSend(localeText(c, "habits-text", views), markup)
+}
 
-	db.Find(&journeys, "user_id = ?", c.Sender().ID)
-	db.Find(&entries, "user_id = ?", c.Sender().ID)
-	db.Find(&tasks, "user_id = ?", c.Sender().ID)
+func habitNew(c telebot.Context) error {
+	var count int64
+	db.Model(&Habit{}).Where("user_id = ? AND is_archived = ?", c.Sender().ID, false).Count(&count)
+	if int(count) >= configInt("habit_max_count") {
+		return c.Send(localeText(c, "habits-too-much", configInt("habit_max_count")))
+	}
 
-	for _, j := range journeys {
-		activities = append(activities, Activity{CreatedAt: j.CreatedAt, Type: "journey", Item: j})
+	msg, name, err := i.Listen(&cauliflower.ListenOptions{
+		Context: c,
+		Message: localeText(c, "habits-ask-name"),
+	})
+	if err != nil {
+		return nil
 	}
 
-	for _, e := range entries {
-		activities = append(activities, Activity{CreatedAt: e.CreatedAt, Type: "entry", Item: e})
+	msg, answer, err := i.Listen(&cauliflower.ListenOptions{
+		Context: c,
+		Message: localeText(c, "habits-ask-frequency"),
+		Edit:    msg,
+	})
+	if err != nil {
+		return nil
 	}
 
-	for _, t := range tasks {
-		activities = append(activities, Activity{CreatedAt: t.CreatedAt, Type: "task", Item: t})
+	frequency, err := strconv.Atoi(strings.TrimSpace(answer.Text))
+	if err != nil || frequency < 1 || frequency > 7 {
+		_, err = b.Edit(msg, localeText(c, "habits-invalid-number", map[string]int{"Min": 1, "Max": 7}))
+		return err
 	}
 
-	sort.SliceStable(activities, func(i, j int) bool {
-		return activities[i].CreatedAt.Before(activities[j].CreatedAt)
-	})
+	maxPoints := configInt("habit_max_points")
 
-	data := map[string]interface{}{
-		"activity": activities,
-		"journeys": journeys,
-		"entries":  entries,
-		"tasks":    tasks,
+	msg, answer, err = i.Listen(&cauliflower.ListenOptions{
+		Context: c,
+		Message: localeText(c, "habits-ask-points", maxPoints),
+		Edit:    msg,
+	})
+	if err != nil {
+		return nil
 	}
 
-	marshaled, err := yaml.Marshal(&data)
-	if err != nil {
+	points, err := strconv.Atoi(strings.TrimSpace(answer.Text))
+	if err != nil || points < 1 || points > maxPoints {
+		_, err = b.Edit(msg, localeText(c, "habits-invalid-number", map[string]int{"Min": 1, "Max": maxPoints}))
 		return err
 	}
 
-	document := telebot.Document{
-		File:     telebot.FromReader(bytes.NewReader(marshaled)),
-		Caption:  lt.Text(c, "account-download-document"),
-		MIME:     "text/yaml",
-		FileName: "data.yml",
+	habit := Habit{
+		UserID:    c.Sender().ID,
+		Name:      strings.TrimSpace(name.Text),
+		Frequency: frequency,
+		Points:    points,
 	}
 
-	_, err = document.Send(b, c.Sender(), &telebot.SendOptions{})
+	db.Create(&habit)
 
-	c.Respond()
+	markup := b.NewMarkup()
+	markup.Inline(markup.Row(markup.Data(localeText(c, "habits-button-list"), "habits")))
 
+	_, err = b.Edit(msg, localeText(c, "habits-created", habit), markup)
 	return err
 }
 
-func update() error {
-	var motivations []Motivation
-	var matches []string
+func habitsArchive(c telebot.Context) error {
+	var userHabits []Habit
+	db.Where("user_id = ? AND is_archived = ?", c.Sender().ID, false).Order("id").Find(&userHabits)
 
-	if err := filepath.Walk("motivation", func(path string, file fs.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+	markup := b.NewMarkup()
 
-		if file.IsDir() {
-			return nil
-		}
+	var rows []telebot.Row
+	for _, h := range userHabits {
+		rows = append(rows, markup.Row(markup.Data("🗄️ "+h.Name, "habit-archive", strconv.Itoa(int(h.ID)))))
+	}
 
-		s := strings.Split(file.Name(), ".")
+	rows = append(rows, markup.Row(markup.Data(localeText(c, "pagination-back"), "habits")))
 
-		if len(s) > 4 {
-			matches = append(matches, s[0], s[2])
-			motivationsCategories[s[2]] += 1
+	markup.Inline(rows...)
 
-			place, err := strconv.Atoi(s[1])
-			if err != nil {
-				return err
-			}
+	return c.EditOrSend(localeText(c, "habits-ask-archive"), markup)
+}
+
+func markupHabitArchive(c telebot.Context) error {
+	db.Model(&Habit{}).Where("id = ? AND user_id = ?", c.Callback().Data, c.Sender().ID).Update("is_archived", true)
+
+	return habits(c)
+}
+
+// markupHabitDone saves today's completion of the habit as a done task
+func markupHabitDone(c telebot.Context) error {
+	var h Habit
+	if r := db.First(&h, "id = ? AND user_id = ? AND is_archived = ?", c.Callback().Data, c.Sender().ID, false); errors.Is(r.Error, gorm.ErrRecordNotFound) {
+		return c.Send(localeText(c, "err-button"))
+	}
+
+	// once a day, and no more than the frequency in a week
+	if done, _ := habitProgress(h); done >= h.Target() || habitDoneToday(h) {
+		return c.Respond(&telebot.CallbackResponse{Text: localeText(c, "habits-already-done")})
+	}
+
+	task := Task{
+		CreatedAtStr: time.Now().Format("02 Jan 06 15:04"),
+		UserID:       c.Sender().ID,
+		HabitID:      h.ID,
+		Text:         h.Name,
+		IsDone:       true,
+		Status:       "done",
+	}
+
+	db.Create(&task)
+
+	addScore(c.Sender().ID, "habit", h.Points, fmt.Sprintf("task:%d", task.ID))
+
+	c.Respond(&telebot.CallbackResponse{Text: localeText(c, "habits-done", h)})
+
+	return habits(c)
+}
+
+// habitProgress returns the completions of the current period and the number of consecutive periods
+// (days or weeks) where the target was reached, the current period counts only once reached
+func habitProgress(h Habit) (int, int) {
+	var dates []time.Time
+	db.Model(&Task{}).Where("habit_id = ?", h.ID).Pluck("created_at", &dates)
+
+	done := make(map[time.Time]int)
+	for _, date := range dates {
+		done[h.Period(date)] += 1
+	}
+
+	current := h.Period(time.Now())
+	previous := func(t time.Time) time.Time {
+		if h.IsDaily() {
+			return t.AddDate(0, 0, -1)
+		}
+		return t.AddDate(0, 0, -7)
+	}
+
+	streak := 0
+	if done[current] >= h.Target() {
+		streak += 1
+	}
+
+	for period := previous(current); done[period] >= h.Target(); period = previous(period) {
+		streak += 1
+	}
+
+	return done[current], streak
+}
+
+func habitDoneToday(h Habit) bool {
+	_, midnight := today()
+
+	var count int64
+	db.Model(&Task{}).Where("habit_id = ? AND created_at > ?", h.ID, midnight).Count(&count)
+
+	return count > 0
+}
+
+// commandChallenge sends every challenge not ended yet, with the progress of the user or a button to join it
+func commandChallenge(c telebot.Context) error {
+	var challenges []Challenge
+	db.Where("end > ?", time.Now()).Order("start").Find(&challenges)
+
+	if len(challenges) == 0 {
+		return c.Send(localeText(c, "challenge-none"))
+	}
+
+	for _, ch := range challenges {
+		var participant ChallengeParticipant
+		r := db.First(&participant, "challenge_id = ? AND user_id = ?", ch.ID, c.Sender().ID)
+
+		if r.RowsAffected > 0 {
+			msg, err := b.Send(c.Chat(), challengeText(c, ch, c.Sender().ID))
+			if err != nil {
+				return err
+			}
+
+			// the last message is the one kept up to date
+			db.Model(&participant).Updates(ChallengeParticipant{ChatID: c.Chat().ID, MessageID: msg.ID})
+			continue
+		}
+
+		markup := b.NewMarkup()
+		markup.Inline(markup.Row(markup.Data(localeText(c, "challenge-button-join"), "challenge-join", strconv.Itoa(int(ch.ID)))))
+
+		if err := c.Send(challengeText(c, ch, 0), markup); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func markupChallengeJoin(c telebot.Context) error {
+	var ch Challenge
+	if r := db.First(&ch, "id = ? AND end > ?", c.Callback().Data, time.Now()); r.RowsAffected == 0 {
+		return c.Edit(localeText(c, "challenge-none"))
+	}
+
+	db.Where(ChallengeParticipant{ChallengeID: ch.ID, UserID: c.Sender().ID}).
+		Assign(ChallengeParticipant{ChatID: c.Chat().ID, MessageID: c.Message().ID}).
+		FirstOrCreate(&ChallengeParticipant{})
+
+	return c.Edit(challengeText(c, ch, c.Sender().ID))
+}
+
+// challengeText returns the challenge description, with the progress of the user if userID isn't 0
+func challengeText(c telebot.Context, ch Challenge, userID int64) string {
+	var participants int64
+	db.Model(&ChallengeParticipant{}).Where("challenge_id = ?", ch.ID).Count(&participants)
+
+	data := map[string]any{
+		"Name":         ch.Name,
+		"Category":     "",
+		"Tasks":        ch.Tasks,
+		"CheckIns":     ch.CheckIns,
+		"Points":       ch.Points,
+		"Start":        ch.Start,
+		"End":          ch.End,
+		"Participants": participants,
+		"IsJoined":     userID != 0,
+	}
+
+	if ch.Category != "" {
+		data["Category"] = localeText(c, "task-category-"+ch.Category)
+	}
+
+	if userID != 0 {
+		tasks, checkIns := challengeProgress(ch, userID)
+
+		data["TasksDone"], data["TasksBar"] = tasks, progressBar(tasks, ch.Tasks)
+		data["CheckInsDone"], data["CheckInsBar"] = checkIns, progressBar(checkIns, ch.CheckIns)
+		data["IsCompleted"] = tasks >= ch.Tasks && checkIns >= ch.CheckIns
+	}
+
+	return localeText(c, "challenge-text", data)
+}
+
+// challengeProgress returns the number of done tasks and of days with a check-in of the user during the challenge
+func challengeProgress(ch Challenge, userID int64) (int, int) {
+	var tasks int64
+
+	query := db.Model(&Task{}).Where("tasks.user_id = ? AND tasks.is_done = ? AND tasks.habit_id = 0 AND tasks.updated_at BETWEEN ? AND ?", userID, true, ch.Start, ch.End)
+	if ch.Category != "" {
+		query = query.Joins("JOIN task_data ON task_data.id = tasks.task_id").Where("task_data.category = ?", ch.Category)
+	}
+	query.Count(&tasks)
+
+	var dates []time.Time
+	db.Model(&Entry{}).Where("user_id = ? AND created_at BETWEEN ? AND ?", userID, ch.Start, ch.End).Pluck("created_at", &dates)
+
+	days := make(map[string]bool)
+	for _, date := range dates {
+		days[date.Local().Format("2006-01-02")] = true
+	}
+
+	return int(tasks), len(days)
+}
+
+func progressBar(done, total int) string {
+	if total <= 0 {
+		return ""
+	}
+
+	if done > total {
+		done = total
+	}
+
+	filled := done * 10 / total
+
+	return strings.Repeat("▓", filled) + strings.Repeat("░", 10-filled) + " " + strconv.Itoa(done*100/total) + "%"
+}
+
+// updateChallenges refreshes the progress messages of running challenges and posts the results of ended ones
+func updateChallenges() {
+	var challenges []Challenge
+	db.Where("start < ? AND is_finished = ?", time.Now(), false).Find(&challenges)
+
+	var blockedIDs []int64
+	db.Model(&User{}).Where("is_blocked = ?", true).Pluck("id", &blockedIDs)
+
+	blocked := make(map[int64]bool)
+	for _, id := range blockedIDs {
+		blocked[id] = true
+	}
+
+	for _, ch := range challenges {
+		var participants []ChallengeParticipant
+		db.Find(&participants, "challenge_id = ?", ch.ID)
+
+		if ch.End.After(time.Now()) {
+			for _, p := range participants {
+				if p.MessageID == 0 || blocked[p.UserID] {
+					continue
+				}
+
+				throttle(func() error {
+					return asUser(p.UserID, func(c telebot.Context) error {
+						_, err := b.Edit(&telebot.StoredMessage{
+							MessageID: strconv.Itoa(p.MessageID),
+							ChatID:    p.ChatID,
+						}, challengeText(c, ch, p.UserID))
+
+						// most runs don't change the progress
+						if errors.Is(err, telebot.ErrMessageNotModified) {
+							return nil
+						}
+
+						return err
+					})
+				})
+			}
+
+			continue
+		}
+
+		var winners []string
+		for n, p := range participants {
+			tasks, checkIns := challengeProgress(ch, p.UserID)
+			if tasks < ch.Tasks || checkIns < ch.CheckIns {
+				continue
+			}
+
+			participants[n].IsCompleted = true
+			db.Model(&p).Update("is_completed", true)
+
+			// challenges aren't part of a journey, so there is no cap nor bonus
+			awardScore(ScoreEvent{
+				UserID: p.UserID,
+				Type:   "challenge",
+				Points: ch.Points * currentScoring().Weights["challenge"],
+				Ref:    fmt.Sprintf("challenge:%d", ch.ID),
+				Reason: ch.Name,
+				Date:   ch.End,
+			})
+
+			var user User
+			db.First(&user, p.UserID)
+			winners = append(winners, user.Username)
+		}
+
+		db.Model(&ch).Update("is_finished", true)
+
+		for _, p := range participants {
+			if blocked[p.UserID] {
+				continue
+			}
+
+			throttle(func() error {
+				return asUser(p.UserID, func(c telebot.Context) error {
+					return c.Send(localeText(c, "challenge-results", map[string]any{
+						"Name":         ch.Name,
+						"Points":       ch.Points,
+						"IsCompleted":  p.IsCompleted,
+						"Winners":      winners,
+						"Participants": len(participants),
+					}))
+				})
+			})
+		}
+	}
+}
+
+// adminBroadcast asks the message, the audience filters and the schedule of a broadcast, then sends a preview
+// to confirm it
+func adminBroadcast(c telebot.Context) error {
+	msg, answer, err := i.Listen(&cauliflower.ListenOptions{
+		Context: c,
+		Message: localeText(c, "admin-broadcast-ask-message"),
+	})
+	if err != nil {
+		return nil
+	}
+
+	bc := Broadcast{
+		AuthorID: c.Sender().ID,
+		Text:     answer.Text,
+		Status:   "draft",
+	}
+
+	switch {
+	case answer.Photo != nil:
+		bc.MediaType, bc.FileID = "photo", answer.Photo.FileID
+	case answer.Animation != nil:
+		bc.MediaType, bc.FileID = "animation", answer.Animation.FileID
+	case answer.Video != nil:
+		bc.MediaType, bc.FileID = "video", answer.Video.FileID
+	case answer.Document != nil:
+		bc.MediaType, bc.FileID = "document", answer.Document.FileID
+	}
+
+	if bc.MediaType != "" {
+		bc.Text = answer.Caption
+	}
+
+	msg, answer, err = i.Listen(&cauliflower.ListenOptions{
+		Context: c,
+		Message: localeText(c, "admin-broadcast-ask-filters"),
+		Edit:    msg,
+	})
+	if err != nil {
+		return nil
+	}
+
+	// one filter per line: language fr, journey yes|no, rank memes, inactive 7
+	for _, line := range strings.Split(answer.Text, "\n") {
+		fields := strings.Fields(strings.ToLower(line))
+		if len(fields) == 0 || fields[0] == "-" {
+			continue
+		}
+
+		if len(fields) != 2 {
+			_, err = b.Edit(msg, localeText(c, "admin-broadcast-invalid-filter", line))
+			return err
+		}
+
+		switch fields[0] {
+		case "language":
+			bc.Language = fields[1]
+		case "journey":
+			bc.Journey = fields[1]
+		case "rank":
+			bc.RankSystem = fields[1]
+			if _, ok := getRankSystems()[bc.RankSystem]; !ok {
+				err = errors.New("unknown rank system")
+			}
+		case "inactive":
+			bc.Inactive, err = strconv.Atoi(fields[1])
+		default:
+			err = errors.New("unknown filter")
+		}
+
+		if err != nil || bc.Journey != "" && bc.Journey != "yes" && bc.Journey != "no" {
+			_, err = b.Edit(msg, localeText(c, "admin-broadcast-invalid-filter", line))
+			return err
+		}
+	}
+
+	msg, answer, err = i.Listen(&cauliflower.ListenOptions{
+		Context: c,
+		Message: localeText(c, "admin-broadcast-ask-schedule"),
+		Edit:    msg,
+	})
+	if err != nil {
+		return nil
+	}
+
+	bc.ScheduledAt = time.Now()
+
+	if text := strings.TrimSpace(answer.Text); text != "-" {
+		bc.ScheduledAt, err = time.ParseInLocation("02/01/2006 15:04", text, time.Local)
+		if err != nil {
+			_, err = b.Edit(msg, localeText(c, "admin-challenge-invalid-date"))
+			return err
+		}
+	}
+
+	// the preview is sent like users will receive it, which also checks the markdown
+	if _, err := b.Send(c.Chat(), broadcastMessage(bc)); err != nil {
+		_, err = b.Edit(msg, localeText(c, "admin-broadcast-invalid-message", markdownEscaper.Replace(err.Error())))
+		return err
+	}
+
+	db.Create(&bc)
+
+	id := strconv.Itoa(int(bc.ID))
+
+	markup := b.NewMarkup()
+	markup.Inline(markup.Row(
+		markup.Data(localeText(c, "admin-broadcast-confirm"), "broadcast-confirm", id),
+		markup.Data(localeText(c, "admin-broadcast-cancel"), "broadcast-cancel", id),
+	))
+
+	return c.Send(localeText(c, "admin-broadcast-preview", map[string]any{
+		"Broadcast": bc,
+		"Audience":  len(broadcastAudience(bc)),
+		"Schedule":  bc.ScheduledAt,
+	}), markup)
+}
+
+// markupBroadcast schedules or cancels a broadcast that isn't being sent
+func markupBroadcast(c telebot.Context, status string) error {
+	var bc Broadcast
+	if r := db.First(&bc, "id = ? AND status IN ?", c.Callback().Data, []string{"draft", "scheduled"}); r.RowsAffected == 0 {
+		return c.Edit(localeText(c, "err-button"))
+	}
+
+	db.Model(&bc).Update("status", status)
+
+	return c.Edit(localeText(c, "admin-broadcast-"+status, bc.ScheduledAt))
+}
+
+// adminBroadcasts lists the last broadcasts and their delivery report
+func adminBroadcasts(c telebot.Context) error {
+	var broadcasts []Broadcast
+	db.Order("id DESC").Limit(10).Find(&broadcasts)
+
+	return c.Send(localeText(c, "admin-broadcasts", broadcasts))
+}
+
+// broadcastMessage returns the sendable of the broadcast
+func broadcastMessage(bc Broadcast) interface{} {
+	file := telebot.File{FileID: bc.FileID}
+
+	switch bc.MediaType {
+	case "photo":
+		return &telebot.Photo{File: file, Caption: bc.Text}
+	case "video":
+		return &telebot.Video{File: file, Caption: bc.Text}
+	case "animation":
+		return &telebot.Animation{File: file, Caption: bc.Text}
+	case "document":
+		return &telebot.Document{File: file, Caption: bc.Text}
+	}
+
+	return bc.Text
+}
+
+// broadcastAudience returns the users matching the filters of the broadcast, except the ones who blocked the bot
+func broadcastAudience(bc Broadcast) []User {
+	query := db.Where("is_blocked = ?", false)
+
+	running := "EXISTS (SELECT 1 FROM journeys WHERE journeys.user_id = users.id AND journeys.end = ? AND journeys.deleted_at IS NULL)"
+
+	switch bc.Journey {
+	case "yes":
+		query = query.Where(running, time.Time{})
+	case "no":
+		query = query.Where("NOT "+running, time.Time{})
+	}
+
+	if bc.RankSystem != "" {
+		query = query.Where("EXISTS (SELECT 1 FROM journeys WHERE journeys.user_id = users.id AND journeys.end = ? AND LOWER(journeys.rank_system) = ? AND journeys.deleted_at IS NULL)", time.Time{}, bc.RankSystem)
+	}
+
+	if bc.Inactive > 0 {
+		since := time.Now().AddDate(0, 0, -bc.Inactive)
+
+		query = query.
+			Where("NOT EXISTS (SELECT 1 FROM entries WHERE entries.user_id = users.id AND entries.created_at > ?)", since).
+			Where("NOT EXISTS (SELECT 1 FROM tasks WHERE tasks.user_id = users.id AND tasks.updated_at > ?)", since)
+	}
+
+	var users []User
+	query.Find(&users)
+
+	if bc.Language == "" {
+		return users
+	}
+
+	var audience []User
+	for _, user := range users {
+		if userLocale(user) == bc.Language {
+			audience = append(audience, user)
+		}
+	}
+
+	return audience
+}
+
+// sendBroadcasts sends the scheduled broadcasts through the throttled queue, users who already received one
+// are skipped so an interrupted broadcast resumes, and users who blocked the bot are marked
+func sendBroadcasts() {
+	var broadcasts []Broadcast
+	db.Find(&broadcasts, "status IN ? AND scheduled_at <= ?", []string{"scheduled", "sending"}, time.Now())
+
+	for _, bc := range broadcasts {
+		db.Model(&bc).Update("status", "sending")
+
+		var deliveries []int64
+		db.Model(&BroadcastDelivery{}).Where("broadcast_id = ?", bc.ID).Pluck("user_id", &deliveries)
+
+		delivered := make(map[int64]bool, len(deliveries))
+		for _, userID := range deliveries {
+			delivered[userID] = true
+		}
+
+		for _, user := range broadcastAudience(bc) {
+			if delivered[user.ID] {
+				continue
+			}
+
+			delivery := BroadcastDelivery{BroadcastID: bc.ID, UserID: user.ID, Status: "sent"}
+
+			err := throttle(func() error {
+				_, err := b.Send(user, broadcastMessage(bc))
+				return err
+			})
+
+			switch {
+			case err == nil:
+				bc.Sent++
+			case unreachable(user.ID, err):
+				bc.Blocked++
+				delivery.Status, delivery.Error = "blocked", err.Error()
+			default:
+				bc.Failed++
+				delivery.Status, delivery.Error = "failed", err.Error()
+			}
+
+			db.Create(&delivery)
+			db.Model(&bc).Updates(map[string]any{"sent": bc.Sent, "failed": bc.Failed, "blocked": bc.Blocked})
+		}
+
+		db.Model(&bc).Updates(Broadcast{Status: "done", FinishedAt: time.Now()})
+
+		asUser(bc.AuthorID, func(c telebot.Context) error {
+			return c.Send(localeText(c, "admin-broadcast-report", bc))
+		})
+	}
+}
+
+// adminStats sends the users report: active users, churn and weekly retention cohorts
+func adminStats(c telebot.Context) error {
+	if c.Message().Payload != "users" {
+		return c.Send(localeText(c, "admin-stats-usage"))
+	}
+
+	now := time.Now()
+
+	active := func(since time.Time) (count int64) {
+		db.Model(&User{}).Where("last_seen_at > ?", since).Count(&count)
+		return count
+	}
+
+	var total, inactive, blocked int64
+	db.Model(&User{}).Count(&total)
+	db.Model(&User{}).Where("is_active = ? AND is_blocked = ?", false, false).Count(&inactive)
+	db.Model(&User{}).Where("is_blocked = ?", true).Count(&blocked)
+
+	churn := 0
+	if total > 0 {
+		churn = int((inactive + blocked) * 100 / total)
+	}
+
+	// cohorts by the week of the first journey, retention is the share of the cohort checking in n weeks later
+	type Cohort struct {
+		Week      string
+		Users     int
+		Retention []int
+	}
+
+	monday := now.AddDate(0, 0, -(int(now.Weekday())+6)%7)
+	monday = time.Date(monday.Year(), monday.Month(), monday.Day(), 0, 0, 0, 0, time.Local)
+	from := monday.AddDate(0, 0, -7*7)
+
+	var firsts []struct {
+		UserID int64
+		Start  time.Time
+	}
+
+	// the start column itself is selected, sqlite returns MIN(start) as text without its type
+	db.Model(&Journey{}).
+		Select("DISTINCT journeys.user_id, journeys.start").
+		Where("journeys.start = (SELECT MIN(j.start) FROM journeys j WHERE j.user_id = journeys.user_id AND j.deleted_at IS NULL)").
+		Where("journeys.start >= ?", from).
+		Scan(&firsts)
+
+	cohorts := make([]Cohort, 8)
+	for n := range cohorts {
+		cohorts[n].Week = from.AddDate(0, 0, 7*n).Format("02 Jan")
+		cohorts[n].Retention = make([]int, 8-n)
+	}
+
+	for _, first := range firsts {
+		week := int(first.Start.Sub(from).Hours() / 24 / 7)
+		if week < 0 || week >= len(cohorts) {
+			continue
+		}
+
+		cohorts[week].Users++
+
+		var dates []time.Time
+		db.Model(&Entry{}).Where("user_id = ? AND created_at >= ?", first.UserID, from.AddDate(0, 0, 7*week)).Pluck("created_at", &dates)
+
+		seen := make(map[int]bool)
+		for _, date := range dates {
+			seen[int(date.Sub(from).Hours()/24/7)-week] = true
+		}
+
+		for n := range cohorts[week].Retention {
+			if seen[n] {
+				cohorts[week].Retention[n]++
+			}
+		}
+	}
+
+	for n, cohort := range cohorts {
+		for week, count := range cohort.Retention {
+			if cohort.Users > 0 {
+				cohorts[n].Retention[week] = count * 100 / cohort.Users
+			}
+		}
+	}
+
+	return c.Send(localeText(c, "admin-stats-users", map[string]any{
+		"Total":    total,
+		"Daily":    active(now.AddDate(0, 0, -1)),
+		"Weekly":   active(now.AddDate(0, 0, -7)),
+		"Monthly":  active(now.AddDate(0, -1, 0)),
+		"Inactive": inactive,
+		"Blocked":  blocked,
+		"Churn":    churn,
+		"Cohorts":  cohorts,
+	}))
+}
+
+// adminConfig lists, shows or sets the config values overridable at runtime
+func adminConfig(c telebot.Context) error {
+	args := strings.Fields(c.Message().Payload)
+
+	if len(args) == 0 || args[0] == "list" {
+		values := make(map[string]string)
+		for k := range settingTypes {
+			values[k] = configValue(k)
+		}
+
+		configMutex.RLock()
+		defer configMutex.RUnlock()
+
+		return c.Send(localeText(c, "admin-config-list", map[string]any{
+			"Values":    values,
+			"Overrides": overrides,
+		}))
+	}
+
+	if len(args) < 2 || args[0] != "get" && args[0] != "set" || args[0] == "set" && len(args) < 3 {
+		return c.Send(localeText(c, "admin-config-usage"))
+	}
+
+	key := args[1]
+	if _, ok := settingTypes[key]; !ok {
+		return c.Send(localeText(c, "admin-config-unknown", key))
+	}
+
+	if args[0] == "get" {
+		var changes []SettingChange
+		db.Order("id DESC").Limit(5).Find(&changes, "key = ?", key)
+
+		return c.Send(localeText(c, "admin-config-get", map[string]any{
+			"Key":     key,
+			"Type":    settingTypes[key],
+			"Value":   configValue(key),
+			"Changes": changes,
+		}))
+	}
+
+	value := strings.Join(args[2:], " ")
+	if err := validateSetting(key, value); err != nil {
+		return c.Send(localeText(c, "admin-config-invalid", map[string]any{
+			"Key":   key,
+			"Type":  settingTypes[key],
+			"Error": err.Error(),
+		}))
+	}
+
+	old := configValue(key)
+
+	db.Save(&Setting{Key: key, Value: value})
+	db.Create(&SettingChange{UserID: c.Sender().ID, Key: key, Old: old, New: value})
+	applySetting(key, value)
+
+	return c.Send(localeText(c, "admin-config-set", map[string]any{
+		"Key": key,
+		"Old": old,
+		"New": value,
+	}))
+}
+
+// adminPoints adds (or removes, if negative) points to a user: /points <@username|id> <points> <reason>
+func adminPoints(c telebot.Context) error {
+	if len(c.Args()) < 3 {
+		return c.Send(localeText(c, "admin-points-usage"))
+	}
+
+	var user User
+
+	if id, err := strconv.ParseInt(c.Args()[0], 10, 64); err == nil {
+		db.First(&user, id)
+	} else {
+		db.Last(&user, "username = ?", strings.Trim(c.Args()[0], "@"))
+	}
+
+	points, err := strconv.Atoi(c.Args()[1])
+	if user.ID == 0 || err != nil {
+		return c.Send(localeText(c, "admin-points-usage"))
+	}
+
+	reason := strings.Join(c.Args()[2:], " ")
+
+	awardScore(ScoreEvent{
+		UserID: user.ID,
+		Type:   "admin",
+		Points: points,
+		Ref:    fmt.Sprintf("admin:%d:%d", c.Sender().ID, time.Now().UnixNano()),
+		Reason: reason,
+	})
+
+	asUser(user.ID, func(c telebot.Context) error {
+		return c.Send(localeText(c, "score-adjusted", map[string]any{"Points": points, "Reason": reason}))
+	})
+
+	return c.Send(localeText(c, "admin-points-done", map[string]any{"Username": user.Username, "Points": points}))
+}
+
+// adminAddRank asks the name, bonus and levels of a rank system, a system with the same key is replaced
+func adminAddRank(c telebot.Context) error {
+	msg, name, err := i.Listen(&cauliflower.ListenOptions{
+		Context: c,
+		Message: localeText(c, "admin-rank-ask-name"),
+	})
+	if err != nil {
+		return nil
+	}
+
+	msg, answer, err := i.Listen(&cauliflower.ListenOptions{
+		Context: c,
+		Message: localeText(c, "admin-rank-ask-score"),
+		Edit:    msg,
+	})
+	if err != nil {
+		return nil
+	}
+
+	score, err := strconv.Atoi(strings.TrimSpace(answer.Text))
+	if err != nil || score < 0 || score > 100 {
+		_, err = b.Edit(msg, localeText(c, "admin-task-invalid-number", map[string]int{"Min": 0, "Max": 100}))
+		return err
+	}
+
+	msg, answer, err = i.Listen(&cauliflower.ListenOptions{
+		Context: c,
+		Message: localeText(c, "admin-rank-ask-levels"),
+		Edit:    msg,
+	})
+	if err != nil {
+		return nil
+	}
+
+	system := RankSystem{
+		Key:   strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name.Text)), " ", "-"),
+		Name:  strings.TrimSpace(name.Text),
+		Score: score,
+	}
+
+	hasStart := false
+
+	// one level per line: days | emoji | name | description
+	for _, line := range strings.Split(answer.Text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		fields := strings.Split(line, "|")
+		for len(fields) < 4 {
+			fields = append(fields, "")
+		}
+
+		days, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+		if err != nil || days < 0 || strings.TrimSpace(fields[2]) == "" {
+			_, err = b.Edit(msg, localeText(c, "admin-rank-invalid-level", line))
+			return err
+		}
+
+		hasStart = hasStart || days == 0
+
+		system.Levels = append(system.Levels, RankLevel{
+			Days:        days,
+			Emoji:       strings.TrimSpace(fields[1]),
+			Name:        strings.TrimSpace(fields[2]),
+			Description: strings.TrimSpace(strings.Join(fields[3:], "|")),
+		})
+	}
+
+	if !hasStart {
+		_, err = b.Edit(msg, localeText(c, "admin-rank-no-start"))
+		return err
+	}
+
+	system.IsEdited = true
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return saveRankSystem(tx, system)
+	}); err != nil {
+		return err
+	}
+
+	if err := loadRanks(); err != nil {
+		return err
+	}
+
+	_, err = b.Edit(msg, localeText(c, "admin-rank-created", system))
+	return err
+}
+
+// adminAddChallenge asks the name, task category, goals, start, duration and reward of a new challenge
+func adminAddChallenge(c telebot.Context) error {
+	msg, name, err := i.Listen(&cauliflower.ListenOptions{
+		Context: c,
+		Message: localeText(c, "admin-challenge-ask-name"),
+	})
+	if err != nil {
+		return nil
+	}
+
+	msg, answer, err := i.Listen(&cauliflower.ListenOptions{
+		Context: c,
+		Message: localeText(c, "admin-challenge-ask-category", configStrings("task_categories")),
+		Edit:    msg,
+	})
+	if err != nil {
+		return nil
+	}
+
+	category := strings.ToLower(strings.TrimSpace(answer.Text))
+	if category == "-" {
+		category = ""
+	} else if categories := configStrings("task_categories"); !slices.Contains(categories, category) {
+		_, err = b.Edit(msg, localeText(c, "task-unknown-category", categories))
+		return err
+	}
+
+	msg, answer, err = i.Listen(&cauliflower.ListenOptions{
+		Context: c,
+		Message: localeText(c, "admin-challenge-ask-start"),
+		Edit:    msg,
+	})
+	if err != nil {
+		return nil
+	}
+
+	_, midnight := today()
+	start := midnight
+
+	if text := strings.TrimSpace(answer.Text); text != "-" {
+		start, err = time.ParseInLocation("02/01/2006", text, time.Local)
+		if err != nil {
+			_, err = b.Edit(msg, localeText(c, "admin-challenge-invalid-date"))
+			return err
+		}
+	}
+
+	numbers := []struct {
+		Key      string
+		Min, Max int
+		Value    int
+	}{
+		{Key: "admin-challenge-ask-days", Min: 1, Max: 31},
+		{Key: "admin-challenge-ask-tasks", Min: 0, Max: 100},
+		{Key: "admin-challenge-ask-check-ins", Min: 0, Max: 31},
+		{Key: "admin-challenge-ask-points", Min: 1, Max: 1000},
+	}
+
+	for n := range numbers {
+		msg, answer, err = i.Listen(&cauliflower.ListenOptions{
+			Context: c,
+			Message: localeText(c, numbers[n].Key, numbers[n]),
+			Edit:    msg,
+		})
+		if err != nil {
+			return nil
+		}
+
+		number, err := strconv.Atoi(strings.TrimSpace(answer.Text))
+		if err != nil || number < numbers[n].Min || number > numbers[n].Max {
+			_, err = b.Edit(msg, localeText(c, "admin-task-invalid-number", numbers[n]))
+			return err
+		}
+
+		numbers[n].Value = number
+	}
+
+	ch := Challenge{
+		Name:     strings.TrimSpace(name.Text),
+		Category: category,
+		Start:    start,
+		End:      start.AddDate(0, 0, numbers[0].Value),
+		Tasks:    numbers[1].Value,
+		CheckIns: numbers[2].Value,
+		Points:   numbers[3].Value,
+	}
+
+	db.Create(&ch)
+
+	_, err = b.Edit(msg, localeText(c, "admin-challenge-created")+"\n\n"+challengeText(c, ch, 0))
+	return err
+}
+
+func markupNew(c telebot.Context) error {
+	conv, ok := conversation(c.Sender().ID, "new", "rank")
+	if !ok {
+		return c.Edit(localeText(c, "conversation-expired"))
+	}
+
+	start, err := time.Parse(time.RFC3339, conv.Data["start"])
+	if err != nil {
+		return err
+	}
+
+	rankSystem := c.Callback().Data
+
+	// the rank shown here doesn't need a rank-up notification
+	days := int(time.Now().Sub(start).Hours() / 24)
+	level, _ := rankLevels(rankSystem, days)
+
+	j := Journey{
+		CreatedAtStr: time.Now().Format("02 Jan 06 15:04"),
+		UserID:       c.Sender().ID,
+		RankSystem:   rankSystem,
+		RankLevel:    level,
+		Start:        start,
+	}
+
+	db.Create(&j)
+	endConversation(c.Sender().ID)
+
+	// a journey started in the past already has days
+	addJourneyEvents(j, currentScoring())
+
+	_, rank := getRank(j.Start, j.RankSystem, 0)
+
+	if err := c.Edit(localeText(c, "new-saved", map[string]any{
+		"Rank": rank,
+		"RankSystem": getRankSystem(j.RankSystem).Name,
+		"Start": j.Start,
+		"Days": days,
+	})); err != nil {
+		return err
+	}
+
+	return awardBadges(c)
+}
+
+func markupCheckRelapsed(c telebot.Context) error {
+	conv, ok := conversation(c.Sender().ID, "check", "status")
+	if !ok {
+		return c.Edit(localeText(c, "conversation-expired"))
+	}
+
+	setConversation(&conv, "reason")
+
+	return c.Edit(localeText(c, "relapsed"))
+}
+
+func checkRelapseReason(c telebot.Context, conv *Conversation) error {
+	var j Journey
+	if r := db.Where("user_id = ? AND end = ?", c.Sender().ID, time.Time{}).Limit(1).Find(&j); r.RowsAffected > 0 {
+		j.End, j.Text = time.Now(), c.Text()
+		db.Model(&j).Updates(&Journey{End: j.End, Text: j.Text})
+
+		// the last days since the hourly job
+		addJourneyEvents(j, currentScoring())
+	}
+
+	db.Model(&User{ID: c.Sender().ID}).Update("last_check_in_at", time.Now())
+	endConversation(c.Sender().ID)
+
+	if _, err := b.Edit(conv, localeText(c, "relapsed-saved")); err != nil {
+		return err
+	}
+
+	return awardBadges(c)
+}
+
+func markupCheckSurvived(c telebot.Context) error {
+	conv, ok := conversation(c.Sender().ID, "check", "status")
+	if !ok {
+		return c.Edit(localeText(c, "conversation-expired"))
+	}
+
+	setConversation(&conv, "note")
+
+	return c.Edit(localeText(c, "survived-ask-note"), notesMarkup)
+}
+
+func markupCheckSurvivedNote(c telebot.Context) error {
+	conv, ok := conversation(c.Sender().ID, "check", "note")
+	if !ok {
+		return c.Edit(localeText(c, "conversation-expired"))
+	}
+
+	if _, err := strconv.Atoi(c.Callback().Data); err != nil {
+		return c.Send(localeText(c, "err-button"))
+	}
+
+	conv.Data["note"] = c.Callback().Data
+	setConversation(&conv, "entry")
+
+	return c.Edit(localeText(c, "survived-ask-entry"))
+}
+
+func checkEntry(c telebot.Context, conv *Conversation) error {
+	number, err := strconv.Atoi(conv.Data["note"])
+	if err != nil {
+		return err
+	}
+
+	entry := Entry{
+		CreatedAtStr: time.Now().Format("02 Jan 06 15:04"),
+		UserID:       c.Sender().ID,
+		IsPublic:     false,
+		Note:         number,
+		Text:         c.Text(),
+	}
+
+	db.Create(&entry)
+	db.Model(&User{ID: c.Sender().ID}).Update("last_check_in_at", time.Now())
+	endConversation(c.Sender().ID)
+
+	addScore(c.Sender().ID, "check-in", 1, fmt.Sprintf("entry:%d", entry.ID))
+
+	markup := b.NewMarkup()
+
+	id := strconv.Itoa(int(entry.ID))
+
+	public := markup.Data(localeText(c, "survived-button-public"), "entry-privacy", id, "public")
+	private := markup.Data(localeText(c, "survived-button-private"), "entry-privacy", id, "private")
+
+	markup.Inline(markup.Row(public, private))
+
+	if _, err = b.Edit(conv, localeText(c, "survived-ask-public"), markup); err != nil {
+		return err
+	}
+
+	return awardBadges(c)
+}
+
+func markupTaskDone(c telebot.Context) error {
+	task, ok := pendingTask(c)
+	if !ok {
+		return c.Edit(localeText(c, "task-not-pending"))
+	}
+
+	var taskData TaskData
+	db.First(&taskData, task.TaskID)
+
+	msg := c.Message()
+
+	if taskData.RequiresProof {
+		var answer *telebot.Message
+		var err error
+
+		msg, answer, err = i.Listen(&cauliflower.ListenOptions{
+			Context: c,
+			Message: localeText(c, "task-ask-proof"),
+			Edit:    c.Message(),
+		})
+		if err != nil {
+			return nil
+		}
+
+		// the proof can come after the deadline, or after the expiry job
+		var current Task
+		db.First(&current, task.ID)
+
+		if current.Status != "pending" || !task.Deadline.IsZero() && task.Deadline.Before(time.Now()) {
+			db.Model(&task).Update("status", "expired")
+			_, err = b.Edit(msg, localeText(c, "task-expired", task))
+			return err
+		}
+
+		task.ProofStatus = "pending"
+
+		if answer.Photo != nil {
+			task.ProofFileID = answer.Photo.FileID
+			task.ProofText = answer.Caption
+		} else {
+			task.ProofText = answer.Text
+		}
+
+		// telegram captions are limited to 1024 characters
+		if proof := []rune(task.ProofText); len(proof) > 1024 {
+			task.ProofText = string(proof[:1024])
+		}
+
+		if task.ProofFileID == "" && strings.TrimSpace(task.ProofText) == "" {
+			_, err = b.Edit(msg, localeText(c, "task-proof-empty"), taskMarkup(c, task))
+			return err
+		}
+
+		// without a review chat, proofs are approved right away
+		if configInt64("proof_review_chat") == 0 {
+			task.ProofStatus = "approved"
+		}
+
+		// the task stays pending if the review can't be sent, so it can be done again
+		if task.ProofStatus == "pending" {
+			if err := sendProofReview(c, task); err != nil {
+				b.Edit(msg, taskText(c, task, taskData), taskMarkup(c, task))
+				return err
+			}
+		}
+	}
+
+	if r := db.Model(&task).Updates(Task{
+		IsDone:      true,
+		Status:      "done",
+		ProofText:   task.ProofText,
+		ProofFileID: task.ProofFileID,
+		ProofStatus: task.ProofStatus,
+	}); r.Error != nil {
+		log.Println(r.Error)
+	}
+
+	// proofs waiting for a review give their points once approved
+	if task.ProofStatus != "pending" {
+		addScore(c.Sender().ID, "task", taskData.Points, fmt.Sprintf("task:%d", task.ID))
+	}
+
+	text := localeText(c, "task-done", map[string]any{
+		"Task": task.Text,
+		"GivenAt": task.CreatedAt,
+		"DoneAt": task.UpdatedAt,
+		"Points": taskData.Points,
+		"ProofStatus": task.ProofStatus,
+	})
+
+	if _, err := b.Edit(msg, text); err != nil {
+		return err
+	}
+
+	return awardBadges(c)
+}
+
+// sendProofReview sends the proof of the task to the review chat, where its admins can approve or reject it
+func sendProofReview(c telebot.Context, task Task) error {
+	chat := &telebot.Chat{ID: configInt64("proof_review_chat")}
+
+	proof := markdownEscaper.Replace(task.ProofText)
+
+	text := func() string {
+		return localeText(c, "task-proof-review", map[string]any{
+			"Username": markdownEscaper.Replace(c.Sender().Username),
+			"Task":     task.Text,
+			"Text":     proof,
+		})
+	}
+
+	// telegram captions are limited to 1024 characters, the proof is shortened to fit with the rest
+	caption := text()
+	if over := utf8.RuneCountInString(caption) - 1024; over > 0 {
+		runes := []rune(proof)
+
+		cut := len(runes) - over - 1
+		if cut < 0 {
+			cut = 0
+		}
+
+		proof = strings.TrimRight(string(runes[:cut]), "\\") + "…"
+		caption = text()
+	}
+
+	markup := b.NewMarkup()
+
+	id := strconv.Itoa(int(task.ID))
+
+	markup.Inline(markup.Row(
+		markup.Data(localeText(c, "task-proof-approve"), "proof-approve", id),
+		markup.Data(localeText(c, "task-proof-reject"), "proof-reject", id),
+	))
+
+	if task.ProofFileID != "" {
+		_, err := b.Send(chat, &telebot.Photo{File: telebot.File{FileID: task.ProofFileID}, Caption: caption}, markup)
+		return err
+	}
+
+	_, err := b.Send(chat, caption, markup)
+	return err
+}
+
+// markupProofReview approves or rejects a proof, approved tasks give their points, rejected ones are not done anymore
+func markupProofReview(c telebot.Context, approved bool) error {
+	if !isOwner(c.Sender().ID) {
+		admins, err := b.AdminsOf(c.Chat())
+		if err != nil {
+			return err
+		}
+
+		if !slices.ContainsFunc(admins, func(m telebot.ChatMember) bool { return m.User.ID == c.Sender().ID }) {
+			return c.Respond(&telebot.CallbackResponse{Text: localeText(c, "task-proof-not-admin")})
+		}
+	}
+
+	var task Task
+	if r := db.First(&task, "id = ? AND proof_status = ?", c.Callback().Data, "pending"); r.RowsAffected == 0 {
+		return c.Respond(&telebot.CallbackResponse{Text: localeText(c, "task-not-pending")})
+	}
+
+	key := "task-proof-approved"
+	if approved {
+		db.Model(&task).Update("proof_status", "approved")
+
+		var taskData TaskData
+		db.First(&taskData, task.TaskID)
+
+		addScore(task.UserID, "task", taskData.Points, fmt.Sprintf("task:%d", task.ID))
+	} else {
+		key = "task-proof-rejected"
+		db.Model(&task).Updates(map[string]any{"proof_status": "rejected", "status": "rejected", "is_done": false})
+		revokeScore(task.UserID, fmt.Sprintf("task:%d", task.ID), "proof rejected")
+	}
+
+	asUser(task.UserID, func(c telebot.Context) error {
+		return c.Send(localeText(c, key, task))
+	})
+
+	reviewed := localeText(c, key, task) + "\n" + localeText(c, "task-proof-reviewed-by", c.Sender())
+
+	if c.Message().Photo != nil {
+		return c.EditCaption(reviewed)
+	}
+
+	return c.Edit(reviewed)
+}
+
+func markupTaskSkip(c telebot.Context) error {
+	task, ok := pendingTask(c)
+	if !ok {
+		return c.Edit(localeText(c, "task-not-pending"))
+	}
+
+	db.Model(&task).Update("status", "skipped")
+
+	return c.Edit(localeText(c, "task-skipped", task))
+}
+
+// markupTaskReroll replaces the task by a different one, a limited number of times per day
+func markupTaskReroll(c telebot.Context) error {
+	task, ok := pendingTask(c)
+	if !ok {
+		return c.Edit(localeText(c, "task-not-pending"))
+	}
+
+	now, midnight := today()
+
+	var count int64
+	db.Model(&Task{}).Where("user_id = ? AND status = ? AND updated_at BETWEEN ? AND ?", c.Sender().ID, "rerolled", midnight, now).Count(&count)
+	if int(count) >= configInt("task_rerolls") {
+		return c.Respond(&telebot.CallbackResponse{Text: localeText(c, "task-no-reroll", configInt("task_rerolls"))})
+	}
+
+	taskData, ok := pickTaskData(task.Category, uint(task.TaskID))
+	if !ok {
+		return c.Respond(&telebot.CallbackResponse{Text: localeText(c, "task-none")})
+	}
+
+	db.Model(&task).Update("status", "rerolled")
+
+	previous := task
+
+	task = Task{
+		CreatedAtStr: time.Now().Format("02 Jan 06 15:04"),
+		UserID:       c.Sender().ID,
+		ChatID:       task.ChatID,
+		MessageID:    task.MessageID,
+		TaskID:       int(taskData.ID),
+		Text:         taskDataText(c, taskData),
+		IsDone:       false,
+		Category:     task.Category,
+		Status:       "pending",
+		Deadline:     task.Deadline,
+	}
+
+	db.Create(&task)
+
+	if err := c.Edit(taskText(c, task, taskData), taskMarkup(c, task)); err != nil {
+		db.Unscoped().Delete(&task)
+		db.Model(&previous).Update("status", "pending")
+		return err
+	}
+
+	return nil
+}
+
+// pendingTask returns the task of the button if it's still pending, expiring it if its deadline has passed
+func pendingTask(c telebot.Context) (Task, bool) {
+	var task Task
+
+	if r := db.First(&task, "id = ? AND user_id = ? AND is_done = ? AND status = ?", c.Callback().Data, c.Sender().ID, false, "pending"); r.RowsAffected == 0 {
+		return task, false
+	}
+
+	if !task.Deadline.IsZero() && task.Deadline.Before(time.Now()) {
+		db.Model(&task).Update("status", "expired")
+		return task, false
+	}
+
+	return task, true
+}
+
+func markupMotivationFavorite(c telebot.Context) error {
+	var v MotivationView
+	db.FirstOrCreate(&v, MotivationView{UserID: c.Sender().ID, MotivationUUID: c.Callback().Data})
+
+	fav := !v.IsFavorite
+	db.Model(&v).Updates(map[string]any{"is_favorite": fav})
+
+	key := "motivation-favorite-added"
+	if !fav {
+		key = "motivation-favorite-removed"
+	}
+
+	return c.Respond(&telebot.CallbackResponse{Text: localeText(c, key)})
+}
+
+func markupMotivationDislike(c telebot.Context) error {
+	var v MotivationView
+	db.FirstOrCreate(&v, MotivationView{UserID: c.Sender().ID, MotivationUUID: c.Callback().Data})
+
+	disliked := !v.IsDisliked
+	db.Model(&v).Updates(map[string]any{"is_disliked": disliked})
+
+	key := "motivation-disliked"
+	if !disliked {
+		key = "motivation-undisliked"
+	}
+
+	return c.Respond(&telebot.CallbackResponse{Text: localeText(c, key)})
+}
+
+func markupAccountActivity(c telebot.Context) error {
+	var journeys []Journey
+	var entries []Entry
+	var tasks []Task
+	var rankUps []RankUp
+
+	db.Find(&journeys, "user_id = ?", c.Sender().ID)
+	db.Find(&entries, "user_id = ?", c.Sender().ID)
+	db.Find(&tasks, "user_id = ?", c.Sender().ID)
+	db.Find(&rankUps, "user_id = ?", c.Sender().ID)
+
+	var activities []Activity
+
+	for _, j := range journeys {
+		activities = append(activities, Activity{CreatedAt: j.CreatedAt, Type: "journey", Item: j})
+	}
+
+	for _, e := range entries {
+		activities = append(activities, Activity{CreatedAt: e.CreatedAt, Type: "entry", Item: e})
+	}
+
+	for _, t := range tasks {
+		activities = append(activities, Activity{CreatedAt: t.CreatedAt, Type: "task", Item: t})
+	}
+
+	for _, r := range rankUps {
+		activities = append(activities, Activity{CreatedAt: r.CreatedAt, Type: "rank-up", Item: r})
+	}
+
+	sort.SliceStable(activities, func(i, j int) bool {
+		return activities[i].CreatedAt.Before(activities[j].CreatedAt)
+	})
+
+	markup := b.NewMarkup()
+
+	back := markup.Data(localeText(c, "pagination-back"), "account-back")
+
+	markup.Inline(markup.Row(back))
+
+	return c.Edit(localeText(c, "account-activity-text", activities), markup)
+}
+
+// markupAccountScore shows the last events of the score ledger of the user
+func markupAccountScore(c telebot.Context) error {
+	var events []ScoreEvent
+	db.Order("date DESC").Limit(20).Find(&events, "user_id = ?", c.Sender().ID)
+
+	markup := b.NewMarkup()
+	markup.Inline(markup.Row(markup.Data(localeText(c, "pagination-back"), "account-back")))
+
+	return c.Edit(localeText(c, "account-score-text", events), markup)
+}
+
+func markupAccountRank(c telebot.Context) error {
+	var j Journey
+	if r := db.Where("user_id = ? AND end = ?", c.Sender().ID, time.Time{}).Last(&j); r.RowsAffected == 0 {
+		return c.Respond(&telebot.CallbackResponse{Text: localeText(c, "check-no-journey")})
+	}
+
+	markup := rankSystemsMarkup("account-rank-set")
+	markup.InlineKeyboard = append(markup.InlineKeyboard, []telebot.InlineButton{
+		*markup.Data(localeText(c, "pagination-back"), "account-back").Inline(),
+	})
+
+	return c.Edit(localeText(c, "account-rank-ask", getRankSystem(j.RankSystem)), markup)
+}
+
+// markupAccountRankSet switches the rank system of the running journey, the rank reached in the new system
+// isn't notified
+func markupAccountRankSet(c telebot.Context) error {
+	rank, ok := getRankSystems()[c.Callback().Data]
+	if !ok {
+		return c.Send(localeText(c, "err-button"))
+	}
+
+	var j Journey
+	if r := db.Where("user_id = ? AND end = ?", c.Sender().ID, time.Time{}).Last(&j); r.RowsAffected == 0 {
+		return c.Respond(&telebot.CallbackResponse{Text: localeText(c, "check-no-journey")})
+	}
+
+	level, _ := rankLevels(rank.ID, int(time.Now().Sub(j.Start).Hours()/24))
+	db.Model(&j).Updates(map[string]any{"rank_system": rank.ID, "rank_level": level})
+
+	_, current := getRank(j.Start, rank.ID, 0)
+
+	markup := b.NewMarkup()
+	markup.Inline(markup.Row(markup.Data(localeText(c, "pagination-back"), "account-back")))
+
+	return c.Edit(localeText(c, "account-rank-changed", map[string]any{
+		"RankSystem": rank.Name,
+		"Rank":       current,
+	}), markup)
+}
+
+func markupAccountDownload(c telebot.Context) error {
+	c.Notify(telebot.UploadingDocument)
+
+	var activities []Activity
+	var journeys []Journey
+	var entries []Entry
+	var tasks []Task
+	var rankUps []RankUp
+
+	db.Find(&journeys, "user_id = ?", c.Sender().ID)
+	db.Find(&entries, "user_id = ?", c.Sender().ID)
+	db.Find(&tasks, "user_id = ?", c.Sender().ID)
+	db.Find(&rankUps, "user_id = ?", c.Sender().ID)
+
+	for _, j := range journeys {
+		activities = append(activities, Activity{CreatedAt: j.CreatedAt, Type: "journey", Item: j})
+	}
+
+	for _, e := range entries {
+		activities = append(activities, Activity{CreatedAt: e.CreatedAt, Type: "entry", Item: e})
+	}
+
+	for _, t := range tasks {
+		activities = append(activities, Activity{CreatedAt: t.CreatedAt, Type: "task", Item: t})
+	}
+
+	for _, r := range rankUps {
+		activities = append(activities, Activity{CreatedAt: r.CreatedAt, Type: "rank-up", Item: r})
+	}
+
+	sort.SliceStable(activities, func(i, j int) bool {
+		return activities[i].CreatedAt.Before(activities[j].CreatedAt)
+	})
+
+	data := map[string]interface{}{
+		"activity": activities,
+		"journeys": journeys,
+		"entries":  entries,
+		"tasks":    tasks,
+		"rank-ups": rankUps,
+	}
+
+	marshaled, err := yaml.Marshal(&data)
+	if err != nil {
+		return err
+	}
+
+	document := telebot.Document{
+		File:     telebot.FromReader(bytes.NewReader(marshaled)),
+		Caption:  localeText(c, "account-download-document"),
+		MIME:     "text/yaml",
+		FileName: "data.yml",
+	}
+
+	_, err = document.Send(b, c.Sender(), &telebot.SendOptions{})
+
+	c.Respond()
+
+	return err
+}
+
+func update() error {
+	var motivations []Motivation
+	var matches []string
+
+	// keep the uuids and telegram file ids of known files so views, favorites and cache survive an update
+	var existing []Motivation
+	db.Select("uuid", "path", "file_id").Find(&existing)
+
+	known := make(map[string]Motivation)
+	for _, m := range existing {
+		known[m.Path] = m
+	}
+
+	uuid := func(path string) string {
+		if m, ok := known[path]; ok {
+			return m.UUID
+		}
+		return randomString(16)
+	}
+
+	// optional tags and caption of each id/pack
+	metadata := make(map[string]struct {
+		Tags    []string `yaml:"tags"`
+		Caption string   `yaml:"caption"`
+	})
+
+	if data, err := os.ReadFile(filepath.Join("motivation", "metadata.yml")); err == nil {
+		if err := yaml.Unmarshal(data, &metadata); err != nil {
+			return err
+		}
+	}
+
+	motivationsCategories = make(map[string]int)
+
+	if err := filepath.Walk("motivation", func(path string, file fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if file.IsDir() || file.Name() == "metadata.yml" {
+			return nil
+		}
+
+		mediaType, ok := motivationTypes[strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))]
+		if !ok {
+			log.Printf("updater motivation: unsupported file %s", path)
+			return nil
+		}
+
+		s := strings.Split(file.Name(), ".")
+
+		if len(s) > 4 {
+			matches = append(matches, s[0], s[2])
+			motivationsCategories[s[2]] += 1
+
+			place, err := strconv.Atoi(s[1])
+			if err != nil {
+				return err
+			}
+
+			motivations = append(motivations, Motivation{
+				UUID:      uuid(path),
+				Pack:      s[0],
+				PackPlace: place,
+				Category:  s[2],
+				Language:  s[3],
+				Extension: s[4],
+				Type:      mediaType,
+				Path:      path,
+				FileID:    known[path].FileID,
+			})
+
+			return nil
+		}
+
+		matches = append(matches, s[0], s[1])
+		motivationsCategories[s[1]] += 1
+
+		motivations = append(motivations, Motivation{
+			UUID:      uuid(path),
+			ID:        s[0],
+			Category:  s[1],
+			Language:  s[2],
+			Extension: s[3],
+			Type:      mediaType,
+			Path:      path,
+			FileID:    known[path].FileID,
+		})
+
+		return nil
+	}); err != nil {
+		log.Fatalf("filepath: %v", err)
+	}
+
+	for i, m := range motivations {
+		name := m.ID
+		if m.Pack != "" {
+			name = m.Pack
+		}
+
+		// words of the name are tags too, surrounded by spaces to match whole words with LIKE
+		tags := append(strings.Split(name, "-"), metadata[name].Tags...)
+
+		motivations[i].Tags = " " + strings.Join(tags, " ") + " "
+		motivations[i].Caption = metadata[name].Caption
+
+		matches = append(matches, metadata[name].Tags...)
+	}
+
+	db.Save(&motivations)
+
+	cm = closestmatch.New(removeDuplicate(matches), []int{2})
+
+	return nil
+}
+
+// markupEntryPrivacy makes an entry of the user public or private, the callback data is the entry id and the privacy
+func markupEntryPrivacy(c telebot.Context) error {
+	data := strings.Split(c.Callback().Data, "|")
+	if len(data) < 2 {
+		return c.Send(localeText(c, "err-button"))
+	}
+
+	var entry Entry
+	if r := db.First(&entry, "id = ? AND user_id = ?", data[0], c.Sender().ID); r.RowsAffected == 0 {
+		return c.Send(localeText(c, "err-button"))
+	}
+
+	isPublic := data[1] == "public"
+
+	var privacy, command string
+
+	if isPublic {
+		privacy = localeText(c, "survived-public")
+		command = "/profile"
+	} else {
+		privacy = localeText(c, "survived-private")
+		command = "/account"
+	}
+
+	db.Model(&entry).Update("is_public", isPublic)
+
+	return c.Edit(localeText(c, "survived-saved", map[string]any{
+		"Privacy": privacy,
+		"Note": entry.Note,
+		"Command": command,
+		"Text": entry.Text,
+	}))
+}
+
+func motivationFavorites(c telebot.Context, page int) error {
+	var count int64
+	db.Model(&MotivationView{}).Where("user_id = ? AND is_favorite = ?", c.Sender().ID, true).Count(&count)
+	if count == 0 {
+		return c.EditOrSend(localeText(c, "motivation-favorites-empty"))
+	}
+
+	if page < 1 || int64(page) > count {
+		page = 1
+	}
+
+	var m Motivation
+	db.Joins("JOIN motivation_views ON motivation_views.motivation_uuid = motivations.uuid").
+		Where("motivation_views.user_id = ? AND motivation_views.is_favorite = ?", c.Sender().ID, true).
+		Order("motivation_views.created_at").
+		Offset(page - 1).
+		Take(&m)
+
+	markup := b.NewMarkup()
+
+	var previous, next telebot.Btn
+
+	if page > 1 {
+		previous = markup.Data(localeText(c, "pagination-previous"), "motivation-favorites", strconv.Itoa(page-1))
+	}
+
+	if int64(page) < count {
+		next = markup.Data(localeText(c, "pagination-next"), "motivation-favorites", strconv.Itoa(page+1))
+	}
+
+	favorite := markup.Data("❤️", "motivation-favorite", m.UUID)
+
+	markup.Inline(markup.Row(previous, favorite, next))
+
+	media, err := motivationMedia(m, localeText(c, "motivation-favorites-caption", map[string]any{
+		"Page":    page,
+		"MaxPage": count,
+		"Caption": localeText(c, "motivation-caption", m),
+	}))
+	if err != nil {
+		return err
+	}
+
+	// text messages and medias can't be edited into each other
+	if _, isText := media.(string); c.Callback() != nil && (isText || c.Message().Text != "") {
+		c.Delete()
+		return c.Send(media, markup)
+	}
+
+	return c.EditOrSend(media, markup)
+}
+
+// motivationSuggestions sends the closest ids, packs, categories and tags to arg as buttons
+func motivationSuggestions(c telebot.Context, arg string) error {
+	markup := b.NewMarkup()
+
+	var buttons []telebot.Btn
+	for _, match := range cm.ClosestN(arg, 6) {
+		if match != "" {
+			buttons = append(buttons, markup.Data(match, "motivation-send", callbackArg(match)))
+		}
+	}
+
+	if len(buttons) == 0 {
+		return c.Send(localeText(c, "motivation-not-found", arg))
+	}
+
+	markup.Inline(markup.Split(2, buttons)...)
+
+	return c.Send(localeText(c, "motivation-error", arg), markup)
+}
+
+// motivationSearch searches every word of text in the ids, packs, categories, tags and captions
+func motivationSearch(c telebot.Context, text string) error {
+	if strings.TrimSpace(text) == "" {
+		return c.Send(localeText(c, "motivation-search-usage"))
+	}
+
+	query := db.Model(&Motivation{})
+
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		like := "%" + likeEscaper.Replace(word) + "%"
+		query = query.Where("(LOWER(id) LIKE ? ESCAPE '\\' OR LOWER(pack) LIKE ? ESCAPE '\\' OR LOWER(category) LIKE ? ESCAPE '\\' OR LOWER(tags) LIKE ? ESCAPE '\\' OR LOWER(caption) LIKE ? ESCAPE '\\')", like, like, like, like, like)
+	}
+
+	var motivations []Motivation
+	query.Order("category, pack, id").Find(&motivations)
+
+	// a pack is a single result
+	var names []string
+	for _, m := range motivations {
+		if m.Pack != "" {
+			names = append(names, m.Pack)
+		} else {
+			names = append(names, m.ID)
+		}
+	}
+	names = removeDuplicate(names)
+
+	if len(names) == 0 {
+		return c.Send(localeText(c, "motivation-not-found", text))
+	}
+
+	markup := b.NewMarkup()
+
+	var buttons []telebot.Btn
+	for _, name := range names {
+		buttons = append(buttons, markup.Data(name, "motivation-send", callbackArg(name)))
+		if len(buttons) == 10 {
+			break
+		}
+	}
+
+	markup.Inline(markup.Split(2, buttons)...)
+
+	return c.Send(localeText(c, "motivation-search-results", map[string]any{
+		"Text":  text,
+		"Count": len(names),
+		"Shown": len(buttons),
+	}), markup)
+}
+
+func motivationSubscribe(c telebot.Context, args []string) error {
+	var s Subscription
+	db.Where(Subscription{UserID: c.Sender().ID}).Attrs(Subscription{Time: "09:00"}).FirstOrInit(&s)
+
+	for _, arg := range args {
+		if at, err := time.Parse("15:04", arg); err == nil {
+			s.Time = at.Format("15:04")
+			continue
+		}
+
+		if _, ok := motivationsCategories[arg]; ok {
+			s.Category = arg
+			continue
+		}
+
+		if _, err := time.LoadLocation(arg); err == nil && arg != "" {
+			s.Timezone = arg
+			continue
+		}
+
+		return c.Send(localeText(c, "motivation-subscribe-invalid", arg))
+	}
+
+	// don't send today's motivation right away if the time has already passed
+	s.IsPaused = false
+	s.LastSent = time.Now()
+
+	db.Save(&s)
+
+	return c.Send(localeText(c, "motivation-subscribed", map[string]any{
+		"Category": s.Category,
+		"Time":     s.Time,
+		"Timezone": s.Location().String(),
+	}))
+}
+
+func motivationUnsubscribe(c telebot.Context) error {
+	if r := db.Unscoped().Where("user_id = ?", c.Sender().ID).Delete(&Subscription{}); r.RowsAffected == 0 {
+		return c.Send(localeText(c, "motivation-not-subscribed"))
+	}
+
+	return c.Send(localeText(c, "motivation-unsubscribed"))
+}
+
+func motivationPause(c telebot.Context) error {
+	var s Subscription
+	if r := db.First(&s, "user_id = ?", c.Sender().ID); errors.Is(r.Error, gorm.ErrRecordNotFound) {
+		return c.Send(localeText(c, "motivation-not-subscribed"))
+	}
+
+	paused := !s.IsPaused
+	db.Model(&s).Updates(map[string]any{
+		"is_paused": paused,
+		"last_sent": time.Now(),
+	})
+
+	if !paused {
+		return c.Send(localeText(c, "motivation-resumed"))
+	}
+
+	return c.Send(localeText(c, "motivation-paused"))
+}
+
+// sendSubscriptions sends the due subscriptions one motivation, picked the same way as /motivation,
+// a failed send counts as sent so it's only retried the next day
+func sendSubscriptions() {
+	var subscriptions []Subscription
+	db.Scopes(notBlocked("subscriptions.user_id")).Find(&subscriptions, "is_paused = ?", false)
+
+	for _, s := range subscriptions {
+		if !s.IsDue(time.Now()) {
+			continue
+		}
+
+		m, ok := pickMotivation(s.UserID, s.Category)
+		if !ok {
+			continue
+		}
+
+		if err := throttle(func() error {
+			return asUser(s.UserID, func(c telebot.Context) error {
+				return sendMotivation(c, m)
+			})
+		}); err != nil {
+			log.Printf("subscription %d: %v", s.UserID, err)
+		}
+
+		db.Model(&s).Update("last_sent", time.Now())
+	}
+}
+
+// pickMotivation returns a random motivation matching arg (pack, id or category, anything if empty)
+// among the ones the user has seen the least, disliked ones being counted as already seen a few times
+func pickMotivation(userID int64, arg string) (Motivation, bool) {
+	var m Motivation
+
+	query := db.Joins("LEFT JOIN motivation_views ON motivation_views.motivation_uuid = motivations.uuid AND motivation_views.user_id = ?", userID)
+
+	if arg != "" {
+		query = query.Where("(motivations.pack = ? OR motivations.id = ? OR motivations.category = ? OR motivations.tags LIKE ? ESCAPE '\\')", arg, arg, arg, "% "+likeEscaper.Replace(arg)+" %")
+	}
+
+	r := query.
+		Order("COALESCE(motivation_views.views, 0) + CASE WHEN motivation_views.is_disliked THEN " + strconv.Itoa(motivationDislikeWeight) + " ELSE 0 END").
+		Order("RANDOM()").
+		Take(&m)
+
+	return m, r.RowsAffected > 0
+}
+
+func sendMotivation(c telebot.Context, m Motivation) error {
+	if m.Pack != "" {
+		return sendPack(c, m)
+	}
+
+	media, err := motivationMedia(m, localeText(c, "motivation-caption", m))
+	if err != nil {
+		return err
+	}
+
+	if err := c.Send(media, motivationMarkup(m)); err != nil {
+		return err
+	}
+
+	cacheMotivation(m, media)
+	seeMotivation(c.Sender().ID, m)
+
+	return nil
+}
+
+// motivationMedia returns the sendable matching the motivation type, text quotes are sent
+// as a message followed by the caption
+func motivationMedia(m Motivation, caption string) (interface{}, error) {
+	file := telebot.FromDisk(m.Path)
+	if m.FileID != "" {
+		file = telebot.File{FileID: m.FileID}
+	}
+
+	switch m.Type {
+	case "video":
+		return &telebot.Video{File: file, Caption: caption}, nil
+	case "animation":
+		return &telebot.Animation{File: file, Caption: caption}, nil
+	case "text", "markdown":
+		content, err := os.ReadFile(m.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		text := strings.TrimSpace(string(content))
+		if m.Type == "text" {
+			text = markdownEscaper.Replace(text)
+		}
+
+		return text + "\n\n" + caption, nil
+	default:
+		return &telebot.Photo{File: file, Caption: caption}, nil
+	}
+}
+
+// cacheMotivation saves the telegram file id of a sent motivation, so it's not uploaded again
+// and can be used in inline mode
+func cacheMotivation(m Motivation, media interface{}) {
+	var fileID string
+
+	switch v := media.(type) {
+	case *telebot.Photo:
+		fileID = v.FileID
+	case *telebot.Video:
+		fileID = v.FileID
+	case *telebot.Animation:
+		fileID = v.FileID
+	}
+
+	if fileID != "" && fileID != m.FileID {
+		db.Model(&m).Update("file_id", fileID)
+	}
+}
+
+func motivationMarkup(m Motivation) *telebot.ReplyMarkup {
+	markup := b.NewMarkup()
+
+	favorite := markup.Data("❤️", "motivation-favorite", m.UUID)
+	dislike := markup.Data("👎", "motivation-dislike", m.UUID)
+
+	markup.Inline(markup.Row(favorite, dislike))
+
+	return markup
+}
+
+// seeMotivation increments the views of the motivation, or of the whole pack if it belongs to one
+func seeMotivation(userID int64, m Motivation) {
+	uuids := []string{m.UUID}
+
+	if m.Pack != "" {
+		db.Model(&Motivation{}).Where("pack = ?", m.Pack).Pluck("uuid", &uuids)
+	}
+
+	for _, uuid := range uuids {
+		db.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "user_id"}, {Name: "motivation_uuid"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"views":      gorm.Expr("views + 1"),
+				"updated_at": time.Now(),
+			}),
+		}).Create(&MotivationView{
+			UserID:         userID,
+			MotivationUUID: uuid,
+			Views:          1,
+		})
+	}
+}
+
+func sendPack(c telebot.Context, m Motivation) error {
+	var p []Motivation
+	db.Where("pack = ?", m.Pack).Find(&p)
+
+	sort.SliceStable(p, func(i, j int) bool {
+		return p[i].PackPlace < p[j].PackPlace
+	})
+
+	// photos and videos can be mixed in albums, animations and texts are sent on their own
+	var album telebot.Album
+	var albumItems []Motivation
+
+	// albums need 2 to 10 items, a single one is sent as is
+	sendAlbum := func() error {
+		defer func() { album, albumItems = telebot.Album{}, nil }()
+
+		switch len(album) {
+		case 0:
+			return nil
+		case 1:
+			if err := c.Send(album[0]); err != nil {
+				return err
+			}
+		default:
+			if err := c.SendAlbum(album); err != nil {
+				return err
+			}
+		}
+
+		for i, item := range albumItems {
+			cacheMotivation(item, album[i])
+		}
+
+		return nil
+	}
+
+	for _, item := range p {
+		media, err := motivationMedia(item, "")
+		if err != nil {
+			return err
+		}
+
+		if inputtable, ok := media.(telebot.Inputtable); ok && (item.Type == "photo" || item.Type == "video") {
+			album = append(album, inputtable)
+			albumItems = append(albumItems, item)
+			if len(album) == 10 {
+				if err := sendAlbum(); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if err := sendAlbum(); err != nil {
+			return err
+		}
+
+		if text, ok := media.(string); ok {
+			media = strings.TrimSpace(text)
+		}
+
+		if err := c.Send(media); err != nil {
+			return err
+		}
+
+		cacheMotivation(item, media)
+	}
+
+	if err := sendAlbum(); err != nil {
+		return err
+	}
+
+	if err := c.Send(localeText(c, "motivation-caption", m), motivationMarkup(m)); err != nil {
+		return err
+	}
+
+	seeMotivation(c.Sender().ID, m)
+
+	return nil
+}
+
+// getRank returns the level reached by the journey and its rank with an offset of 0, the days left before the
+// next rank and its name with an offset of 1 (0 and "" at the highest rank), the same way rank-ups are notified
+func getRank(start time.Time, rank string, offset int) (int, string) {
+	days := int(time.Now().Sub(start).Hours() / 24)
+	levels := getRankSystem(rank).Levels
+
+	current, next := rankLevels(rank, days)
+
+	if offset == 0 {
+		return current, levels[current]
+	}
+
+	if next == -1 {
+		return 0, ""
+	}
+
+	return next - days, levels[next]
+}
+
+// awardBadges gives the user the badges whose value is reached and announces them
+func awardBadges(c telebot.Context) error {
+	var owned []string
+	db.Model(&UserBadge{}).Where("user_id = ?", c.Sender().ID).Pluck("badge_id", &owned)
+
+	badges := currentBadges()
+
+	ids := maps.Keys(badges)
+	sort.Strings(ids)
+
+	// values are computed once per type
+	values := make(map[string]int)
+
+	for _, id := range ids {
+		badge := badges[id]
+		if slices.Contains(owned, id) {
+			continue
+		}
+
+		value, ok := values[badge.Type]
+		if !ok {
+			value = badgeValue(c.Sender().ID, badge.Type)
+			values[badge.Type] = value
+		}
+
+		if value < badge.Value {
+			continue
+		}
+
+		db.Create(&UserBadge{UserID: c.Sender().ID, BadgeID: id})
+
+		if err := c.Send(localeText(c, "badge-awarded", map[string]any{
+			"Emoji":       badge.Emoji,
+			"Name":        badgeName(c, badge),
+			"Description": localeText(c, "badge-type-"+badge.Type, badge.Value),
+		})); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func badgeValue(userID int64, badgeType string) int {
+	var count int64
+
+	switch badgeType {
+	case "check-ins":
+		db.Model(&Entry{}).Where("user_id = ?", userID).Count(&count)
+	case "tasks":
+		db.Model(&Task{}).Where("user_id = ? AND is_done = ? AND habit_id = 0", userID, true).Count(&count)
+	case "urges":
+		db.Model(&Urge{}).Where("user_id = ?", userID).Count(&count)
+	case "check-in-streak":
+		var dates []time.Time
+		db.Model(&Entry{}).Where("user_id = ?", userID).Order("created_at").Pluck("created_at", &dates)
+
+		// longest run of consecutive days with a check-in
+		var streak int
+		var last time.Time
+
+		for _, date := range dates {
+			date = date.Local()
+			day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.Local)
+
+			switch {
+			case day.Equal(last):
+				continue
+			case day.Equal(last.AddDate(0, 0, 1)):
+				streak++
+			default:
+				streak = 1
+			}
+
+			last = day
+			if int64(streak) > count {
+				count = int64(streak)
+			}
+		}
+	case "weekends":
+		var journeys []Journey
+		db.Select("start", "end").Where("user_id = ?", userID).Find(&journeys)
+
+		// weekends (saturday and sunday) fully inside a journey
+		for _, j := range journeys {
+			end := j.End
+			if end.IsZero() {
+				end = time.Now()
+			}
+
+			start := j.Start.Local()
+			monday := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.Local)
+			monday = monday.AddDate(0, 0, (8-int(monday.Weekday()))%7)
+
+			for ; !monday.After(end); monday = monday.AddDate(0, 0, 7) {
+				if !monday.AddDate(0, 0, -2).Before(j.Start) {
+					count++
+				}
+			}
+		}
+	}
+
+	return int(count)
+}
+
+// badgeName returns the translated name of the badge, or its id without translation
+func badgeName(c telebot.Context, badge Badge) string {
+	if name := localeText(c, "badge-"+badge.ID); name != "" {
+		return name
+	}
+
+	return badge.ID
+}
+
+// seedRanks saves the bot.yml rank systems when the table is empty, or replaces the systems
+// with the same keys when reloading, except the ones edited with /add-rank
+func seedRanks(tx *gorm.DB, config map[string]Rank, replace bool) error {
+	var count int64
+	if err := tx.Model(&RankSystem{}).Count(&count).Error; err != nil {
+		return err
+	}
+
+	if count > 0 && !replace {
+		return nil
+	}
+
+	// levels used to be soft deleted when their system was replaced
+	if err := tx.Unscoped().Where("deleted_at IS NOT NULL").Delete(&RankLevel{}).Error; err != nil {
+		return err
+	}
+
+	for key, rank := range config {
+		system := RankSystem{Key: strings.ToLower(key), Name: rank.Name, Score: rank.Score}
+
+		for days, name := range rank.Levels {
+			system.Levels = append(system.Levels, RankLevel{Days: days, Name: name})
+		}
+
+		var old RankSystem
+		r := tx.Preload("Levels").Limit(1).Find(&old, "key = ?", system.Key)
+		if r.Error != nil {
+			return r.Error
+		}
+
+		// unchanged systems keep their rows, so the watcher doesn't rewrite every system at each reload
+		if r.RowsAffected > 0 && (old.IsEdited || sameRankSystem(old, system)) {
+			continue
+		}
+
+		if err := saveRankSystem(tx, system); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// saveRankSystem creates the rank system, replacing the one with the same key, tx should be a transaction
+func saveRankSystem(tx *gorm.DB, system RankSystem) error {
+	var old RankSystem
+	if r := tx.Limit(1).Find(&old, "key = ?", system.Key); r.Error != nil {
+		return r.Error
+	} else if r.RowsAffected > 0 {
+		if err := tx.Unscoped().Delete(&RankLevel{}, "rank_system_id = ?", old.ID).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Delete(&old).Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.Create(&system).Error
+}
+
+// sameRankSystem returns true if both systems have the same name, bonus and levels
+func sameRankSystem(a, b RankSystem) bool {
+	if a.Name != b.Name || a.Score != b.Score || len(a.Levels) != len(b.Levels) {
+		return false
+	}
+
+	levels := make(map[int]RankLevel)
+	for _, level := range a.Levels {
+		levels[level.Days] = level
+	}
+
+	for _, level := range b.Levels {
+		old, ok := levels[level.Days]
+		if !ok || old.Name != level.Name || old.Emoji != level.Emoji || old.Description != level.Description {
+			return false
+		}
+	}
+
+	return true
+}
+
+// readRanks returns the rank systems of the database as cached in ranks
+func readRanks(tx *gorm.DB) (map[string]Rank, error) {
+	var systems []RankSystem
+	if r := tx.Preload("Levels").Find(&systems); r.Error != nil {
+		return nil, r.Error
+	}
+
+	loaded := make(map[string]Rank)
+
+	for _, system := range systems {
+		rank := Rank{
+			ID:           system.Key,
+			Name:         system.Name,
+			Score:        system.Score,
+			Levels:       make(map[int]string),
+			Descriptions: make(map[int]string),
+		}
+
+		for _, level := range system.Levels {
+			rank.Levels[level.Days] = strings.TrimSpace(level.Emoji + " " + level.Name)
+			rank.Descriptions[level.Days] = level.Description
+		}
+
+		loaded[system.Key] = rank
+	}
+
+	return loaded, nil
+}
+
+// loadRanks replaces the ranks cache with the database rank systems
+func loadRanks() error {
+	systems, err := readRanks(db)
+	if err != nil {
+		return err
+	}
+
+	configMutex.Lock()
+	ranks = systems
+	configMutex.Unlock()
+
+	return nil
+}
+
+// getRankSystem returns the cached rank system of the key, an empty one if it doesn't exist
+func getRankSystem(key string) Rank {
+	return getRankSystems()[strings.ToLower(key)]
+}
+
+// getRankSystems returns the cached rank systems, the map is replaced and never modified when reloading
+func getRankSystems() map[string]Rank {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	return ranks
+}
+
+// rankSystemsMarkup returns a button per rank system, with the key of the system as data
+func rankSystemsMarkup(unique string) *telebot.ReplyMarkup {
+	systems := getRankSystems()
+
+	keys := maps.Keys(systems)
+	sort.Strings(keys)
+
+	markup := b.NewMarkup()
+
+	var buttons []telebot.Btn
+	for _, key := range keys {
+		buttons = append(buttons, markup.Data(systems[key].Name, unique, key))
+	}
+
+	markup.Inline(markup.Split(2, buttons)...)
+
+	return markup
+}
+
+// rankLevels returns the highest level (days) of the rank system reached after days, and the next one (-1 if none)
+func rankLevels(system string, days int) (int, int) {
+	keys := maps.Keys(getRankSystem(system).Levels)
+	sort.Ints(keys)
+
+	current, next := 0, -1
+
+	for _, key := range keys {
+		if key > days {
+			next = key
+			break
+		}
+
+		current = key
+	}
+
+	return current, next
+}
+
+// backfillRankLevels saves the level reached by the running journeys without notifying it
+func backfillRankLevels() {
+	var journeys []Journey
+	db.Where("end = ? AND rank_system <> ''", time.Time{}).Find(&journeys)
+
+	for _, j := range journeys {
+		level, _ := rankLevels(j.RankSystem, int(time.Now().Sub(j.Start).Hours()/24))
+		db.Model(&j).Update("rank_level", level)
+	}
+}
+
+// notifyRankUps congratulates users whose running journey reached a new level of their rank system since the
+// last check, logs the rank-up and optionally sends a motivation about the rank system
+func notifyRankUps() {
+	var journeys []Journey
+	db.Scopes(notBlocked("journeys.user_id")).Where("end = ? AND rank_system <> ''", time.Time{}).Find(&journeys)
+
+	for _, j := range journeys {
+		days := int(time.Now().Sub(j.Start).Hours() / 24)
+
+		level, next := rankLevels(j.RankSystem, days)
+		if level <= j.RankLevel {
+			continue
+		}
+
+		db.Model(&j).Update("rank_level", level)
+
+		rank := getRankSystem(j.RankSystem)
+		levels := rank.Levels
+
+		db.Create(&RankUp{
+			CreatedAtStr: time.Now().Format("02 Jan 06 15:04"),
+			UserID:       j.UserID,
+			JourneyID:    j.ID,
+			RankSystem:   j.RankSystem,
+			Level:        level,
+			Rank:         levels[level],
+		})
+
+		data := map[string]any{
+			"Rank":        levels[level],
+			"Description": rank.Descriptions[level],
+			"Days":        days,
+			"NextRank":    "",
+			"DaysLeft":    0,
+		}
+
+		if next != -1 {
+			data["NextRank"], data["DaysLeft"] = levels[next], next-days
+		}
+
+		throttle(func() error {
+			return asUser(j.UserID, func(c telebot.Context) error {
+				if err := c.Send(localeText(c, "rank-up", data)); err != nil {
+					return err
+				}
+
+				if !configBool("rank_up_motivation") {
+					return nil
+				}
+
+				m, ok := pickMotivation(j.UserID, strings.ToLower(j.RankSystem))
+				if !ok {
+					m, ok = pickMotivation(j.UserID, "")
+				}
+
+				if ok {
+					return sendMotivation(c, m)
+				}
+
+				return nil
+			})
+		})
+	}
+}
+
+// startConversation starts (or restarts) a flow of the user at state, msg is the message edited by the next steps
+func startConversation(c telebot.Context, msg *telebot.Message, flow, state string) {
+	conv := Conversation{
+		UserID:    c.Sender().ID,
+		Flow:      flow,
+		Data:      make(map[string]string),
+		ChatID:    msg.Chat.ID,
+		MessageID: msg.ID,
+	}
+
+	setConversation(&conv, state)
+}
+
+// setConversation saves the state of the conversation and extends its timeout
+func setConversation(conv *Conversation, state string) {
+	if conv.Data == nil {
+		conv.Data = make(map[string]string)
+	}
+
+	conv.State = state
+	conv.ExpiresAt = time.Now().Add(configDuration("conversation_timeout"))
+
+	db.Save(conv)
+}
+
+// conversation returns the running conversation of the user if it's in the flow and one of the states
+func conversation(userID int64, flow string, states ...string) (Conversation, bool) {
+	var conv Conversation
+	if r := db.First(&conv, "user_id = ? AND expires_at > ?", userID, time.Now()); r.RowsAffected == 0 {
+		return conv, false
+	}
+
+	if flow != "" && conv.Flow != flow || len(states) > 0 && !slices.Contains(states, conv.State) {
+		return conv, false
+	}
+
+	return conv, true
+}
+
+func endConversation(userID int64) {
+	db.Delete(&Conversation{}, "user_id = ?", userID)
+}
+
+// onText passes text messages to the state of the running conversation of the user
+func onText(c telebot.Context) error {
+	conv, ok := conversation(c.Sender().ID, "")
+	if !ok {
+		return nil
+	}
+
+	handler, ok := flows[conv.Flow][conv.State]
+	if !ok {
+		return nil
+	}
+
+	return handler(c, &conv)
+}
+
+// commandCancel ends the conversation of the user, cauliflower calls it too when /cancel answers a listener
+func commandCancel(c telebot.Context) error {
+	// the message of the conversation says it's canceled, otherwise the answer does
+	if conv, ok := conversation(c.Sender().ID, ""); ok {
+		endConversation(c.Sender().ID)
+
+		if _, err := b.Edit(conv, localeText(c, "err-command-canceled")); err == nil {
+			return nil
+		}
+	}
+
+	return c.Send(localeText(c, "err-command-canceled"))
+}
+
+// expireConversations ends the conversations without answer before the timeout, nothing has been written yet
+func expireConversations() {
+	var conversations []Conversation
+	db.Find(&conversations, "expires_at < ?", time.Now())
+
+	for _, conv := range conversations {
+		endConversation(conv.UserID)
+
+		asUser(conv.UserID, func(c telebot.Context) error {
+			_, err := b.Edit(conv, localeText(c, "err-no-message-received"))
+			return err
+		})
+	}
+}
+
+// callbackArg returns value if it fits in the callback data (64 bytes with the unique), otherwise stores it in
+// a CallbackToken valid for a week and returns the token, prefixed with ~
+func callbackArg(value string) string {
+	if len(value) <= 40 && !strings.HasPrefix(value, "~") {
+		return value
+	}
+
+	token := CallbackToken{
+		Token:     randomString(16),
+		Value:     value,
+		ExpiresAt: time.Now().AddDate(0, 0, 7),
+	}
+
+	db.Create(&token)
+
+	return "~" + token.Token
+}
+
+// callbackValue returns the value given to callbackArg, false if its token expired
+func callbackValue(c telebot.Context) (string, bool) {
+	data := c.Callback().Data
+	if !strings.HasPrefix(data, "~") {
+		return data, true
+	}
+
+	var token CallbackToken
+	if r := db.First(&token, "token = ? AND expires_at > ?", strings.TrimPrefix(data, "~"), time.Now()); r.RowsAffected == 0 {
+		return "", false
+	}
+
+	return token.Value, true
+}
+
+var numbers = regexp.MustCompile(`[0-9]+`)
+
+// recordError logs the error and saves it, its kind groups the errors of the same place and message
+func recordError(e ErrorLog) {
+	where := e.Command
+	if e.Callback != "" {
+		where = strings.Split(e.Callback, "|")[0]
+	}
+
+	e.Kind = where + ": " + numbers.ReplaceAllString(e.Error, "#")
+	if len(e.Kind) > 255 {
+		e.Kind = e.Kind[:255]
+	}
+
+	log.Printf("error user=%d command=%q callback=%q: %s", e.UserID, e.Command, e.Callback, e.Error)
+
+	// error_logs calls are ignored by the database callback, so a failing insert doesn't loop
+	db.Create(&e)
+}
+
+// escapeError returns the error with its fields escaped for html messages
+func escapeError(e ErrorLog) ErrorLog {
+	e.Command = html.EscapeString(e.Command)
+	e.Callback = html.EscapeString(e.Callback)
+	e.Kind = html.EscapeString(e.Kind)
+	e.Error = html.EscapeString(e.Error)
+	e.Stack = html.EscapeString(e.Stack)
+
+	return e
+}
+
+// reportErrors records the errors and panics of the handlers with their context, and answers the user with a
+// generic error instead
+func reportErrors(next telebot.HandlerFunc) telebot.HandlerFunc {
+	return func(c telebot.Context) (err error) {
+		var e ErrorLog
+		if c.Sender() != nil {
+			e.UserID = c.Sender().ID
+		}
+
+		if c.Callback() != nil {
+			e.Callback = strings.TrimSpace(c.Callback().Unique + "|" + c.Callback().Data)
+		} else if text := c.Text(); strings.HasPrefix(text, "/") {
+			e.Command = strings.Fields(text)[0]
+		}
+
+		defer func() {
+			if r := recover(); r != nil {
+				e.Error = fmt.Sprint("panic: ", r)
+				e.Stack = string(debug.Stack())
+			} else if err != nil {
+				e.Error = err.Error()
+			} else {
+				return
+			}
+
+			recordError(e)
+
+			err = c.Send(localeText(c, "err-generic"))
+		}()
+
+		return next(c)
+	}
+}
+
+// runJob runs a background job, its panic is recorded instead of stopping the bot
+func runJob(name string, job func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			recordError(ErrorLog{Command: "job " + name, Error: fmt.Sprint("panic: ", r), Stack: string(debug.Stack())})
+		}
+	}()
+
+	job()
+}
+
+// logDatabaseErrors records the errors of every database call, except missing records
+func logDatabaseErrors() {
+	callback := func(tx *gorm.DB) {
+		if tx.Error == nil || errors.Is(tx.Error, gorm.ErrRecordNotFound) || tx.Statement.Table == "error_logs" {
+			return
+		}
+
+		// the query is kept instead of the stack
+		recordError(ErrorLog{Command: "database", Error: tx.Error.Error(), Stack: tx.Statement.SQL.String()})
+	}
+
+	// writes are recorded once their transaction is over, sqlite would lock the error insert otherwise
+	db.Callback().Create().After("gorm:commit_or_rollback_transaction").Register("errors:create", callback)
+	db.Callback().Query().After("gorm:query").Register("errors:query", callback)
+	db.Callback().Update().After("gorm:commit_or_rollback_transaction").Register("errors:update", callback)
+	db.Callback().Delete().After("gorm:commit_or_rollback_transaction").Register("errors:delete", callback)
+	db.Callback().Row().After("gorm:row").Register("errors:row", callback)
+	db.Callback().Raw().After("gorm:raw").Register("errors:raw", callback)
+}
+
+// sendErrorsDigest sends the owners the kinds of errors seen for the first time since the last digest
+func sendErrorsDigest() {
+	var errs []ErrorLog
+	db.Order("id").Find(&errs, "reported = ?", false)
+
+	if len(errs) == 0 {
+		return
+	}
+
+	var known []string
+	db.Model(&ErrorLog{}).Distinct("kind").Where("reported = ?", true).Pluck("kind", &known)
+
+	// counts by escaped kind, as the kinds sent
+	counts := make(map[string]int)
+	var kinds []ErrorLog
+
+	for _, e := range errs {
+		if slices.Contains(known, e.Kind) {
+			continue
+		}
+
+		if counts[html.EscapeString(e.Kind)] == 0 {
+			kinds = append(kinds, escapeError(e))
+		}
+
+		counts[html.EscapeString(e.Kind)]++
+	}
+
+	db.Model(&ErrorLog{}).Where("reported = ? AND id <= ?", false, errs[len(errs)-1].ID).Update("reported", true)
+
+	if len(kinds) == 0 {
+		return
+	}
+
+	// the rest is in /errors
+	if len(kinds) > 20 {
+		kinds = kinds[:20]
+	}
+
+	for _, owner := range configInt64s("owners") {
+		throttle(func() error {
+			return asUser(owner, func(c telebot.Context) error {
+				return c.Send(localeText(c, "admin-errors-digest", map[string]any{
+					"Errors": kinds,
+					"Counts": counts,
+				}), telebot.ModeHTML, telebot.NoPreview)
+			})
+		})
+	}
+}
+
+// adminErrors lists the last errors, or shows one with its stack: /errors [id]
+func adminErrors(c telebot.Context) error {
+	if len(c.Args()) > 0 {
+		var e ErrorLog
+		if r := db.First(&e, c.Args()[0]); r.RowsAffected == 0 {
+			return c.Send(localeText(c, "admin-errors-none"))
+		}
+
+		if len(e.Stack) > 3000 {
+			e.Stack = e.Stack[:3000]
+		}
+
+		return c.Send(localeText(c, "admin-error", escapeError(e)), telebot.ModeHTML)
+	}
+
+	var errs []ErrorLog
+	db.Order("id DESC").Limit(15).Find(&errs)
+
+	if len(errs) == 0 {
+		return c.Send(localeText(c, "admin-errors-none"))
+	}
+
+	for n := range errs {
+		errs[n] = escapeError(errs[n])
+	}
+
+	return c.Send(localeText(c, "admin-errors", errs), telebot.ModeHTML)
+}
+
+// asUser runs handler with a context addressed to the user's private chat, with its locale set,
+// so handlers can be reused outside of updates
+func asUser(userID int64, handler telebot.HandlerFunc) error {
+	c := b.NewContext(telebot.Update{Message: &telebot.Message{
+		Sender: &telebot.User{ID: userID},
+		Chat:   &telebot.Chat{ID: userID},
+	}})
+
+	err := handler(c)
+	if unreachable(userID, err) {
+		return err
+	}
+
+	if err != nil {
+		recordError(ErrorLog{UserID: userID, Command: "job", Error: err.Error()})
+	}
+
+	return err
+}
+
+// loadedConfig is bot.yml and the locales, used with useConfig once everything is valid
+type loadedConfig struct {
+	Layout  *layout.Layout
+	Ranks   map[string]Rank // bot.yml rank systems, seeded into the database
+	Scoring Scoring
+	Badges  map[string]Badge
+}
+
+// loadConfig parses bot.yml and checks the locales, nothing is replaced so an invalid config keeps the old one
+func loadConfig() (loadedConfig, error) {
+	newLt, err := layout.New("bot.yml", templateFuncs)
+	if err != nil {
+		return loadedConfig{}, fmt.Errorf("layout: %w", err)
+	}
+
+	// lt.Text returns empty texts for missing keys and broken templates
+	if err := checkLocales("locales"); err != nil {
+		return loadedConfig{}, err
+	}
+
+	// owners and task categories are only checked, they're read from the layout with their overrides
+	var (
+		newOwners     []int64
+		newRanks      = make(map[string]Rank)
+		newScoring    Scoring
+		newBadges     = make(map[string]Badge)
+		newCategories []string
+	)
+
+	if err := newLt.UnmarshalKey("owners", &newOwners); err != nil {
+		return loadedConfig{}, fmt.Errorf("layout owners: %w", err)
+	}
+
+	if err := newLt.UnmarshalKey("ranks", &newRanks); err != nil {
+		return loadedConfig{}, fmt.Errorf("layout ranks: %w", err)
+	}
+
+	if err := newLt.UnmarshalKey("scoring", &newScoring); err != nil {
+		return loadedConfig{}, fmt.Errorf("layout scoring: %w", err)
+	}
+
+	if err := newLt.UnmarshalKey("badges", &newBadges); err != nil {
+		return loadedConfig{}, fmt.Errorf("layout badges: %w", err)
+	}
+
+	if newLt.Duration("error_digest_interval") <= 0 {
+		return loadedConfig{}, errors.New("layout error_digest_interval: must be a positive duration")
+	}
+
+	for id, badge := range newBadges {
+		badge.ID = id
+		newBadges[id] = badge
+	}
+
+	if err := newLt.UnmarshalKey("task_categories", &newCategories); err != nil {
+		return loadedConfig{}, fmt.Errorf("layout task categories: %w", err)
+	}
+
+	return loadedConfig{Layout: newLt, Ranks: newRanks, Scoring: newScoring, Badges: newBadges}, nil
+}
+
+// useConfig replaces the config in use at once, with the saved settings and the database rank systems
+func useConfig(config loadedConfig, settings map[string]string, systems map[string]Rank) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	lt, scoring, badges, overrides, ranks = config.Layout, config.Scoring, config.Badges, settings, systems
+}
+
+// currentLayout returns the layout in use, replaced when reloading
+func currentLayout() *layout.Layout {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	return lt
+}
+
+func currentScoring() Scoring {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	return scoring
+}
+
+func currentBadges() map[string]Badge {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	return badges
+}
+
+// reload replaces the layout, the config, the bot.yml rank systems and the commands without restarting,
+// the settings overrides are applied again on top of bot.yml, nothing is replaced if any of them fails
+func reload() error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	settings, err := readSettings()
+	if err != nil {
+		return fmt.Errorf("settings: %w", err)
+	}
+
+	// before anything is replaced, so the old config is really kept if telegram refuses the commands
+	if config.Layout.Bool("set_commands") {
+		if err := b.SetCommands(config.Layout.Commands()); err != nil {
+			return fmt.Errorf("commands: %w", err)
+		}
+	}
+
+	var systems map[string]Rank
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := seedRanks(tx, config.Ranks, true); err != nil {
+			return err
+		}
+
+		systems, err = readRanks(tx)
+		return err
+	}); err != nil {
+		return fmt.Errorf("ranks: %w", err)
+	}
+
+	useConfig(config, settings, systems)
+
+	// the available locales may have changed
+	languageMutex.Lock()
+	usersLanguage = make(map[int64]string)
+	languageMutex.Unlock()
+
+	return nil
+}
+
+// reportReloadError sends the owners why the config wasn't reloaded
+func reportReloadError(err error) {
+	log.Printf("reload: %v", err)
+
+	for _, owner := range configInt64s("owners") {
+		asUser(owner, func(c telebot.Context) error {
+			return c.Send(localeText(c, "admin-reload-failed", markdownEscaper.Replace(err.Error())))
+		})
+	}
+}
+
+// configModTime returns the last modification of bot.yml and the locales
+func configModTime() time.Time {
+	var modified time.Time
+
+	files, _ := filepath.Glob("locales/*.yml")
+
+	for _, file := range append(files, "bot.yml") {
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
+
+	return modified
+}
 
-			motivations = append(motivations, Motivation{
-				UUID:      randomString(16),
-				Pack:      s[0],
-				PackPlace: place,
-				Category:  s[2],
-				Language:  s[3],
-				Extension: s[4],
-				Path:      path,
-			})
+// adminReload reloads bot.yml and the locales, the old config is kept if the new one is invalid
+func adminReload(c telebot.Context) error {
+	if err := reload(); err != nil {
+		reportReloadError(err)
+		return nil
+	}
 
-			return nil
+	return c.Send(localeText(c, "admin-reload-success", map[string]any{
+		"Locales": currentLayout().Locales(),
+		"Ranks":   len(getRankSystems()),
+	}))
+}
+
+// readSettings returns the valid settings saved with /config, applied over bot.yml
+func readSettings() (map[string]string, error) {
+	var saved []Setting
+	if err := db.Find(&saved).Error; err != nil {
+		return nil, err
+	}
+
+	settings := make(map[string]string)
+
+	for _, setting := range saved {
+		if err := validateSetting(setting.Key, setting.Value); err != nil {
+			log.Printf("setting %s ignored: %v", setting.Key, err)
+			continue
 		}
 
-		matches = append(matches, s[0], s[1])
-		motivationsCategories[s[1]] += 1
+		settings[setting.Key] = setting.Value
+	}
 
-		motivations = append(motivations, Motivation{
-			UUID:      randomString(16),
-			ID:        s[0],
-			Category:  s[1],
-			Language:  s[2],
-			Extension: s[3],
-			Path:      path,
-		})
+	return settings, nil
+}
 
-		return nil
-	}); err != nil {
-		log.Fatalf("filepath: %v", err)
+// validateSetting checks that the key is overridable and the value parses as its type,
+// lists are separated by commas
+func validateSetting(key, value string) error {
+	var err error
+
+	switch settingTypes[key] {
+	case "string":
+	case "int":
+		_, err = strconv.Atoi(value)
+	case "int64":
+		_, err = strconv.ParseInt(value, 10, 64)
+	case "bool":
+		_, err = strconv.ParseBool(value)
+	case "duration":
+		var d time.Duration
+		if d, err = time.ParseDuration(value); err == nil && d <= 0 {
+			err = errors.New("not a positive duration")
+		}
+	case "int64s":
+		for _, item := range splitSetting(value) {
+			if _, err = strconv.ParseInt(item, 10, 64); err != nil {
+				break
+			}
+		}
+	case "strings":
+	default:
+		return errors.New("unknown key")
 	}
 
-	db.Create(&motivations)
+	if err == nil && strings.HasSuffix(settingTypes[key], "s") && len(splitSetting(value)) == 0 {
+		err = errors.New("empty list")
+	}
 
-	cm = closestmatch.New(removeDuplicate(matches), []int{2})
+	return err
+}
 
-	return nil
+// applySetting overrides the config value, the value must be valid
+func applySetting(key, value string) {
+	configMutex.Lock()
+	overrides[key] = value
+	configMutex.Unlock()
 }
 
-func handlePrivacy(c telebot.Context, isPublic bool, number int, answer string) error {
-	var privacy, command string
+func splitSetting(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
 
-	if isPublic {
-		privacy = lt.Text(c, "survived-public")
-		command = "/profile"
-	} else {
-		privacy = lt.Text(c, "survived-private")
-		command = "/account"
+	return items
+}
+
+func override(key string) (string, bool) {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	value, ok := overrides[key]
+	return value, ok
+}
+
+// configValue returns the current value of an overridable key as /config shows it
+func configValue(key string) string {
+	switch settingTypes[key] {
+	case "int64s":
+		var items []string
+		for _, n := range configInt64s(key) {
+			items = append(items, strconv.FormatInt(n, 10))
+		}
+		return strings.Join(items, ",")
+	case "strings":
+		return strings.Join(configStrings(key), ",")
+	case "int":
+		return strconv.Itoa(configInt(key))
+	case "int64":
+		return strconv.FormatInt(configInt64(key), 10)
+	case "bool":
+		return strconv.FormatBool(configBool(key))
+	case "duration":
+		return configDuration(key).String()
 	}
 
-	entry := &Entry{
-		UserID: c.Sender().ID,
-		Note:   number,
-		Text:   answer,
+	return configString(key)
+}
+
+func configString(key string) string {
+	if value, ok := override(key); ok {
+		return value
 	}
 
-	db.Model(&entry).Where(&entry).Updates(Entry{IsPublic: isPublic})
+	return currentLayout().String(key)
+}
 
-	return c.Edit(lt.Text(c, "survived-saved", map[string]any{
-		"Privacy": privacy,
-		"Note": entry.Note,
-		"Command": command,
-		"Text": entry.Text,
-	}))
+func configInt(key string) int {
+	if value, ok := override(key); ok {
+		n, _ := strconv.Atoi(value)
+		return n
+	}
+
+	return currentLayout().Int(key)
 }
 
-func sendPack(c telebot.Context, m Motivation) error {
-	var p []Motivation
-	db.Where("pack = ?", m.Pack).Find(&p)
+func configInt64(key string) int64 {
+	if value, ok := override(key); ok {
+		n, _ := strconv.ParseInt(value, 10, 64)
+		return n
+	}
 
-	sort.SliceStable(p, func(i, j int) bool {
-		return p[i].PackPlace < p[j].PackPlace
-	})
+	return currentLayout().Int64(key)
+}
 
-	var album telebot.Album
+func configBool(key string) bool {
+	if value, ok := override(key); ok {
+		b, _ := strconv.ParseBool(value)
+		return b
+	}
+
+	return currentLayout().Bool(key)
+}
+
+func configDuration(key string) time.Duration {
+	if value, ok := override(key); ok {
+		d, _ := time.ParseDuration(value)
+		return d
+	}
 
-	for _, image := range p {
-		album = append(album, &telebot.Photo{File: telebot.FromDisk(image.Path)})
-		if len(album) == 10 {
-			c.SendAlbum(album)
-			album = telebot.Album{}
+	return currentLayout().Duration(key)
+}
+
+func configInt64s(key string) []int64 {
+	if value, ok := override(key); ok {
+		var list []int64
+		for _, item := range splitSetting(value) {
+			n, _ := strconv.ParseInt(item, 10, 64)
+			list = append(list, n)
 		}
+		return list
 	}
 
-	if len(album) > 0 {
-		c.SendAlbum(album)
+	return currentLayout().Int64s(key)
+}
+
+func configStrings(key string) []string {
+	if value, ok := override(key); ok {
+		return splitSetting(value)
 	}
 
-	return c.Send(lt.Text(c, "motivation-caption", m))
+	return currentLayout().Strings(key)
 }
 
-func getRank(start time.Time, rank string, offset int) (int, string) {
-	days := int(time.Now().Sub(start).Hours() / 24)
-	levels := ranks[strings.ToLower(rank)].Levels
+// isOwner reads the owners at each call, /config can change them
+func isOwner(userID int64) bool {
+	return slices.Contains(configInt64s("owners"), userID)
+}
 
-	keys := maps.Keys(levels)
-	sort.Ints(keys)
+// ownersOnly restricts a group to the owners, like middleware.Whitelist but following /config changes
+func ownersOnly(next telebot.HandlerFunc) telebot.HandlerFunc {
+	return func(c telebot.Context) error {
+		if c.Sender() == nil || !isOwner(c.Sender().ID) {
+			return nil
+		}
+
+		return next(c)
+	}
+}
+
+// seeUser saves the user and marks them active, a user sending updates didn't block the bot,
+// the user is only saved again after 5 minutes or when their username or language changes
+func seeUser(sender *telebot.User) {
+	if sender == nil {
+		return
+	}
+
+	seenMutex.Lock()
+	seen, ok := seenUsers[sender.ID]
+	if ok && seen.Username == sender.Username && seen.LanguageCode == sender.LanguageCode &&
+		time.Since(seen.LastSeenAt) < 5*time.Minute {
+		seenMutex.Unlock()
+		return
+	}
+
+	seenUsers[sender.ID] = User{Username: sender.Username, LanguageCode: sender.LanguageCode, LastSeenAt: time.Now()}
+	seenMutex.Unlock()
+
+	db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"username", "language_code", "is_active", "is_blocked", "last_seen_at", "updated_at"}),
+	}).Create(&User{
+		ID:           sender.ID,
+		Username:     sender.Username,
+		LanguageCode: sender.LanguageCode,
+		IsActive:     true,
+		LastSeenAt:   time.Now(),
+	})
+}
+
+// expireUsers marks the users not seen for inactive_days as inactive
+func expireUsers() {
+	db.Model(&User{}).
+		Where("is_active = ? AND last_seen_at < ?", true, time.Now().AddDate(0, 0, -configInt("inactive_days"))).
+		Update("is_active", false)
+}
+
+// unreachable marks the user as blocked when err means the bot can't send them messages anymore
+func unreachable(userID int64, err error) bool {
+	if !errors.Is(err, telebot.ErrBlockedByUser) && !errors.Is(err, telebot.ErrUserIsDeactivated) &&
+		!errors.Is(err, telebot.ErrNotStartedByUser) && !errors.Is(err, telebot.ErrChatNotFound) {
+		return false
+	}
+
+	db.Model(&User{ID: userID}).Updates(map[string]any{"is_blocked": true, "is_active": false})
+
+	// the next update of the user unblocks them
+	seenMutex.Lock()
+	delete(seenUsers, userID)
+	seenMutex.Unlock()
+
+	return true
+}
+
+// notBlocked filters out the rows of the users the bot can't message anymore, column being their id
+func notBlocked(column string) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("NOT EXISTS (SELECT 1 FROM users WHERE users.id = " + column + " AND users.is_blocked)")
+	}
+}
+
+// throttle waits for the global sending rate (telegram allows ~30 messages/second) before calling send,
+// and retries up to 3 times after the delay telegram asks for when flooding anyway
+func throttle(send func() error) error {
+	<-sendTicker.C
+
+	err := send()
+
+	var flood telebot.FloodError
+	for retry := 0; retry < 3 && errors.As(err, &flood); retry++ {
+		time.Sleep(time.Duration(flood.RetryAfter) * time.Second)
+		err = send()
+	}
+
+	return err
+}
+
+// localeText returns the text in the locale of the user, resolved at each call from the current layout so
+// handlers still waiting for an answer keep working when reloading
+func localeText(c telebot.Context, key string, args ...any) string {
+	locale := "fr"
+	if c.Sender() != nil {
+		locale = userLocale(c.Sender())
+	}
+
+	return currentLayout().TextLocale(locale, key, args...)
+}
+
+// userLocale returns the language chosen with /language, or the telegram language, if there is a locale for it,
+// fr otherwise
+func userLocale(r telebot.Recipient) string {
+	userID, err := strconv.ParseInt(r.Recipient(), 10, 64)
+	if err != nil {
+		log.Printf("i18n middleware strconv: %v", err)
+	}
+
+	languageMutex.RLock()
+	lang, ok := usersLanguage[userID]
+	languageMutex.RUnlock()
+
+	if ok {
+		return lang
+	}
 
-	for key, value := range keys {
-		if days <= value {
-			return value, levels[keys[key+offset]]
+	var user User
+	db.Select("language", "language_code").Limit(1).Find(&user, userID)
+
+	lang = user.Language
+	if lang == "" {
+		lang = user.LanguageCode
+	}
+
+	// regional codes (pt-br, es-419) use the locale of their language
+	locales := currentLayout().Locales()
+	if !slices.Contains(locales, lang) {
+		lang, _, _ = strings.Cut(strings.ToLower(lang), "-")
+
+		if !slices.Contains(locales, lang) {
+			lang = "fr"
 		}
 	}
 
-	return 0, ""
+	languageMutex.Lock()
+	usersLanguage[userID] = lang
+	languageMutex.Unlock()
+
+	return lang
 }
 
+var markdownEscaper = strings.NewReplacer("_", "\\_", "*", "\\*", "`", "\\`", "[", "\\[")
+
+// likeEscaper escapes the wildcards of a LIKE pattern, used with ESCAPE '\'
+var likeEscaper = strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_")
+
 func randomString(n int) string {
 	var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
 
@@ -1149,46 +4715,327 @@ func randomString(n int) string {
 }
 
 func calculateScore(userID int64, allJourneys bool) int {
-	score := 0
+	return scoreBreakdown(userID, allJourneys).Total()
+}
 
-	var tasks []Task
-	var entries int64
+// scoreBreakdown sums the score ledger per event type, on all journeys or only since the start of the current one
+func scoreBreakdown(userID int64, allJourneys bool) Score {
+	var score Score
 
-	if allJourneys {
-		var journeys []Journey
-		db.Select("start", "end").Where("user_id = ?", userID).Find(&journeys)
+	var since time.Time
+	if !allJourneys {
+		var j Journey
+		if r := db.Select("start").Where("user_id = ? AND end = ?", userID, time.Time{}).Last(&j); r.RowsAffected == 0 {
+			return score
+		}
 
-		for _, j := range journeys {
-			end := j.End
-			if end.IsZero() {
-				end = time.Now()
+		since = j.Start
+	}
+
+	var sums []struct {
+		Type   string
+		Points int
+	}
+
+	db.Model(&ScoreEvent{}).
+		Select("type, SUM(points) AS points").
+		Where("user_id = ? AND date > ?", userID, since).
+		Group("type").
+		Scan(&sums)
+
+	for _, sum := range sums {
+		switch sum.Type {
+		case "day":
+			score.Days = sum.Points
+		case "check-in":
+			score.CheckIns = sum.Points
+		case "task":
+			score.Tasks = sum.Points
+		case "habit":
+			score.Habits = sum.Points
+		case "streak":
+			score.Streaks = sum.Points
+		case "challenge":
+			score.Challenges = sum.Points
+		case "bonus":
+			score.Bonus = sum.Points
+		case "admin":
+			score.Admin = sum.Points
+		}
+	}
+
+	return score
+}
+
+// awardScore appends an event to the score ledger, an event already in it (same user and ref) is ignored
+func awardScore(event ScoreEvent) {
+	if event.Date.IsZero() {
+		event.Date = time.Now()
+	}
+
+	db.Clauses(clause.OnConflict{DoNothing: true}).Create(&event)
+}
+
+// revokeScore appends the opposite of the events of ref (and of their bonus), so the ledger stays append-only
+func revokeScore(userID int64, ref, reason string) {
+	var events []ScoreEvent
+	db.Find(&events, "user_id = ? AND ref IN ?", userID, []string{ref, "bonus:" + ref})
+
+	for _, event := range events {
+		awardScore(ScoreEvent{
+			UserID: userID,
+			Type:   event.Type,
+			Points: -event.Points,
+			Ref:    event.Ref + ":revoked",
+			Reason: reason,
+		})
+	}
+}
+
+// rebuildScores appends the events the user should have according to the scoring rules and that are missing
+// from the ledger: days and streak bonuses of the journeys, check-ins, tasks and habits (capped per day),
+// completed challenges, and the bonus of the rank system (Rank.Score in percent) of the journey containing each event
+func rebuildScores(userID int64) {
+	scoring := currentScoring()
+
+	var journeys []Journey
+	db.Select("id", "start", "end", "rank_system").Where("user_id = ?", userID).Order("start").Find(&journeys)
+
+	// percent returns the rank system bonus of the journey containing t
+	percent := func(t time.Time) int {
+		for n := len(journeys) - 1; n >= 0; n-- {
+			j := journeys[n]
+			if !t.Before(j.Start) && (j.End.IsZero() || !t.After(j.End)) {
+				return getRankSystem(j.RankSystem).Score
 			}
-			score += int(end.Sub(j.Start).Hours()/24) * 2
 		}
 
-		db.Select("task_id").Where("user_id = ?", userID).Find(&tasks)
-		db.Model(&Entry{}).Where("user_id = ?", userID).Count(&entries)
-	} else {
-		var j Journey
-		db.Select("start").Where("user_id = ? AND end = ?", userID, time.Time{}).Last(&j)
+		return 0
+	}
+
+	var events []ScoreEvent
+
+	for _, j := range journeys {
+		events = append(events, journeyEvents(j, 0, scoring)...)
+	}
+
+	// counted returns true if the event is under the daily cap of its type
+	counts := make(map[string]int)
+	counted := func(eventType string, t time.Time) bool {
+		key := eventType + t.Local().Format("2006-01-02")
+		counts[key]++
+
+		limit, ok := scoring.Caps[eventType]
+		return !ok || counts[key] <= limit
+	}
 
-		if !j.Start.IsZero() {
-			score += int(time.Now().Sub(j.Start).Hours()/24) * 2
-			db.Select("task_id").Where("user_id = ? AND updated_at > ?", userID, j.Start).Find(&tasks)
-			db.Model(&Entry{}).Where("user_id = ? AND created_at > ?", userID, j.Start).Count(&entries)
+	var entries []Entry
+	db.Select("id", "created_at").Where("user_id = ?", userID).Order("created_at").Find(&entries)
+
+	for _, e := range entries {
+		if counted("check-in", e.CreatedAt) {
+			events = append(events, ScoreEvent{
+				Type:   "check-in",
+				Points: scoring.Weights["check-in"],
+				Ref:    fmt.Sprintf("entry:%d", e.ID),
+				Date:   e.CreatedAt,
+			})
 		}
 	}
 
-	for _, task := range tasks {
-		var taskData TaskData
-		db.First(&taskData, task.TaskID)
+	var tasks []struct {
+		ID        uint
+		HabitID   uint
+		Points    int
+		UpdatedAt time.Time
+	}
+
+	// habits can be archived, so they're joined without checking deleted_at
+	db.Table("tasks").
+		Select("tasks.id, tasks.habit_id, tasks.updated_at, COALESCE(habits.points, task_data.points, 0) AS points").
+		Joins("LEFT JOIN task_data ON task_data.id = tasks.task_id AND tasks.habit_id = 0").
+		Joins("LEFT JOIN habits ON habits.id = tasks.habit_id").
+		Where("tasks.user_id = ? AND tasks.is_done = ? AND tasks.proof_status <> ? AND tasks.deleted_at IS NULL", userID, true, "pending").
+		Order("tasks.updated_at").
+		Scan(&tasks)
+
+	for _, t := range tasks {
+		eventType := "task"
+		if t.HabitID != 0 {
+			eventType = "habit"
+		}
+
+		if counted(eventType, t.UpdatedAt) {
+			events = append(events, ScoreEvent{
+				Type:   eventType,
+				Points: t.Points * scoring.Weights[eventType],
+				Ref:    fmt.Sprintf("task:%d", t.ID),
+				Date:   t.UpdatedAt,
+			})
+		}
+	}
 
-		score += taskData.Points
+	var challenges []Challenge
+	db.Model(&Challenge{}).
+		Joins("JOIN challenge_participants ON challenge_participants.challenge_id = challenges.id").
+		Where("challenge_participants.user_id = ? AND challenge_participants.is_completed = ?", userID, true).
+		Find(&challenges)
+
+	for _, ch := range challenges {
+		events = append(events, ScoreEvent{
+			Type:   "challenge",
+			Points: ch.Points * scoring.Weights["challenge"],
+			Ref:    fmt.Sprintf("challenge:%d", ch.ID),
+			Reason: ch.Name,
+			Date:   ch.End,
+		})
+	}
+
+	for _, event := range events {
+		// challenges aren't part of a journey
+		if event.Type != "challenge" {
+			if bonus := event.Points * percent(event.Date) / 100; bonus != 0 {
+				events = append(events, ScoreEvent{
+					Type:   "bonus",
+					Points: bonus,
+					Ref:    "bonus:" + event.Ref,
+					Date:   event.Date,
+				})
+			}
+		}
 	}
 
-	score += int(entries)
+	for n := range events {
+		events[n].UserID = userID
+	}
 
-	return score
+	if len(events) > 0 {
+		db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&events, 100)
+	}
+}
+
+// journeyEvents returns the events of the days survived in the journey after the day from, and of its streaks
+func journeyEvents(j Journey, from int, scoring Scoring) []ScoreEvent {
+	var events []ScoreEvent
+
+	end := j.End
+	if end.IsZero() {
+		end = time.Now()
+	}
+
+	days := int(end.Sub(j.Start).Hours() / 24)
+
+	for n := from + 1; n <= days; n++ {
+		events = append(events, ScoreEvent{
+			Type:   "day",
+			Points: scoring.Weights["day"],
+			Ref:    fmt.Sprintf("day:%d:%d", j.ID, n),
+			Date:   j.Start.AddDate(0, 0, n),
+		})
+	}
+
+	for streak, bonus := range scoring.Streaks {
+		if days >= streak && streak > from {
+			events = append(events, ScoreEvent{
+				Type:   "streak",
+				Points: bonus,
+				Ref:    fmt.Sprintf("streak:%d:%d", j.ID, streak),
+				Date:   j.Start.AddDate(0, 0, streak),
+			})
+		}
+	}
+
+	return events
+}
+
+// rebuildAllScores backfills the ledger of every user
+func rebuildAllScores() {
+	var users []int64
+	db.Model(&User{}).Pluck("id", &users)
+
+	for _, userID := range users {
+		rebuildScores(userID)
+	}
+}
+
+// addJourneyDays appends the days survived since the last run to the ledger of the running journeys,
+// everything else is added when it happens so the whole history isn't rebuilt every hour
+func addJourneyDays() {
+	scoring := currentScoring()
+
+	var journeys []Journey
+	db.Select("id", "user_id", "start", "end", "rank_system").Where("end = ?", time.Time{}).Find(&journeys)
+
+	for _, j := range journeys {
+		addJourneyEvents(j, scoring)
+	}
+}
+
+// addJourneyEvents appends the days of the journey missing from the ledger, with their streaks and bonus
+func addJourneyEvents(j Journey, scoring Scoring) {
+	// the days are added in order, so their count is the last one in the ledger
+	var from int64
+	db.Model(&ScoreEvent{}).Where("user_id = ? AND type = ? AND ref LIKE ?", j.UserID, "day", fmt.Sprintf("day:%d:%%", j.ID)).Count(&from)
+
+	events := journeyEvents(j, int(from), scoring)
+	if len(events) == 0 {
+		return
+	}
+
+	percent := getRankSystem(j.RankSystem).Score
+
+	for _, event := range events {
+		if bonus := event.Points * percent / 100; bonus != 0 {
+			events = append(events, ScoreEvent{
+				Type:   "bonus",
+				Points: bonus,
+				Ref:    "bonus:" + event.Ref,
+				Date:   event.Date,
+			})
+		}
+	}
+
+	for n := range events {
+		events[n].UserID = j.UserID
+	}
+
+	db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&events, 100)
+}
+
+// addScore appends the event of a check-in, task or habit done now and the bonus of the rank system of the running
+// journey, unless the daily cap of its type is reached: handlers add their own event, rebuildScores is for repairs
+func addScore(userID int64, eventType string, points int, ref string) {
+	scoring := currentScoring()
+	now, midnight := today()
+
+	if limit, ok := scoring.Caps[eventType]; ok {
+		var count int64
+		db.Model(&ScoreEvent{}).Where("user_id = ? AND type = ? AND date BETWEEN ? AND ? AND ref NOT LIKE ?", userID, eventType, midnight, now, "%:revoked").Count(&count)
+
+		if int(count) >= limit {
+			return
+		}
+	}
+
+	event := ScoreEvent{UserID: userID, Type: eventType, Points: points * scoring.Weights[eventType], Ref: ref, Date: now}
+	awardScore(event)
+
+	var j Journey
+	if r := db.Select("rank_system").Where("user_id = ? AND end = ?", userID, time.Time{}).Limit(1).Find(&j); r.RowsAffected == 0 {
+		return
+	}
+
+	if bonus := event.Points * getRankSystem(j.RankSystem).Score / 100; bonus != 0 {
+		awardScore(ScoreEvent{UserID: userID, Type: "bonus", Points: bonus, Ref: "bonus:" + ref, Date: now})
+	}
+}
+
+// userLocation returns the timezone set with /motivation subscribe, the server one otherwise
+func userLocation(userID int64) *time.Location {
+	var s Subscription
+	db.Where("user_id = ?", userID).Limit(1).Find(&s)
+
+	return s.Location()
 }
 
 func today() (time.Time, time.Time) {
@@ -1209,12 +5056,3 @@ func removeDuplicate[T string | int](sliceList []T) []T {
 	}
 	return list
 }
-
-func removeSlice[T comparable](l []T, item T) []T {
-	for i, other := range l {
-		if other == item {
-			return append(l[:i], l[i+1:]...)
-		}
-	}
-	return l
-}
@@ -7,10 +7,77 @@ import (
 )
 
 type Rank struct {
-	ID     string
+	ID           string
+	Name         string
+	Score        int
+	Levels       map[int]string // days -> emoji and name
+	Descriptions map[int]string
+}
+
+// RankSystem is a rank system stored in the database, bot.yml ranks are only used to fill an empty table
+type RankSystem struct {
+	gorm.Model
+	Key    string `gorm:"uniqueIndex"`
 	Name   string
-	Score  int
-	Levels map[int]string
+	Score    int  // bonus in percent
+	IsEdited bool // saved with /add-rank, bot.yml doesn't replace it when reloading
+	Levels   []RankLevel
+}
+
+type RankLevel struct {
+	gorm.Model
+	RankSystemID uint
+	Days         int
+	Emoji        string
+	Name         string
+	Description  string
+}
+
+type Badge struct {
+	ID    string
+	Emoji string
+	Type  string // check-ins, check-in-streak, tasks, weekends or urges
+	Value int    // value of the type to reach
+}
+
+type UserBadge struct {
+	UserID    int64  `gorm:"primaryKey"`
+	BadgeID   string `gorm:"primaryKey"`
+	CreatedAt time.Time
+}
+
+type Scoring struct {
+	Weights map[string]int // points per event type (day, check-in, challenge) or multiplier of the points (task, habit)
+	Caps    map[string]int // events counted per day, no cap if missing
+	Streaks map[int]int    // bonus when a journey reaches days
+}
+
+// Score is the breakdown of a score, Bonus is what rank systems multipliers add and Admin the adjustments
+type Score struct {
+	Days       int
+	CheckIns   int
+	Tasks      int
+	Habits     int
+	Streaks    int
+	Challenges int
+	Bonus      int
+	Admin      int
+}
+
+func (s Score) Total() int {
+	return s.Days + s.CheckIns + s.Tasks + s.Habits + s.Streaks + s.Challenges + s.Bonus + s.Admin
+}
+
+// ScoreEvent is an append-only row of the score ledger, Ref identifies what was awarded (entry:12, task:3,
+// day:<journey>:<day>...) so the same thing is never awarded twice
+type ScoreEvent struct {
+	gorm.Model
+	UserID int64  `gorm:"uniqueIndex:idx_score_event"`
+	Type   string // day, check-in, task, habit, streak, challenge, bonus or admin
+	Points int
+	Ref    string `gorm:"uniqueIndex:idx_score_event"`
+	Reason string
+	Date   time.Time // when the points were earned
 }
 
 type Motivation struct {
@@ -21,13 +88,70 @@ type Motivation struct {
 	Category  string
 	Language  string
 	Extension string
+	Type      string
 	Path      string
+	FileID    string
+	Tags      string
+	Caption   string
+}
+
+type MotivationView struct {
+	UserID         int64  `gorm:"primaryKey"`
+	MotivationUUID string `gorm:"primaryKey"`
+	Views          int
+	IsFavorite     bool
+	IsDisliked     bool
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+type Subscription struct {
+	gorm.Model
+	UserID   int64 `gorm:"uniqueIndex"`
+	Category string
+	Time     string
+	Timezone string
+	IsPaused bool
+	LastSent time.Time
+}
+
+func (s Subscription) Location() *time.Location {
+	if s.Timezone == "" {
+		return time.Local
+	}
+
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.Local
+	}
+
+	return loc
+}
+
+// IsDue returns true if the subscription time has passed today (in the user's timezone)
+// and nothing has been sent since
+func (s Subscription) IsDue(now time.Time) bool {
+	at, err := time.Parse("15:04", s.Time)
+	if err != nil {
+		return false
+	}
+
+	now = now.In(s.Location())
+	scheduled := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+
+	return !now.Before(scheduled) && s.LastSent.Before(scheduled)
 }
 
 type User struct {
 	gorm.Model
-	ID       int64 `gorm:"primaryKey"`
-	Username string
+	ID            int64 `gorm:"primaryKey"`
+	Username      string
+	IsActive      bool      // seen during the last inactive_days
+	IsBlocked     bool      // the bot can't send messages to the user anymore
+	LastSeenAt    time.Time // last update received from the user
+	LastCheckInAt time.Time
+	Language      string // chosen with /language, the telegram language is used when empty
+	LanguageCode  string // telegram language
 }
 
 func (u User) Recipient() string {
@@ -39,11 +163,22 @@ type Journey struct {
 	CreatedAtStr string `yaml:"createdat"`
 	UserID       int64  `yaml:"-"`
 	RankSystem   string
+	RankLevel    int `yaml:"-"` // last level reached (days) that was notified
 	Start        time.Time
 	End          time.Time
 	Text         string
 }
 
+type RankUp struct {
+	gorm.Model   `yaml:"-"`
+	CreatedAtStr string `yaml:"createdat"`
+	UserID       int64  `yaml:"-"`
+	JourneyID    uint   `yaml:"-"`
+	RankSystem   string
+	Level        int // days
+	Rank         string
+}
+
 type Entry struct {
 	gorm.Model   `yaml:"-"`
 	CreatedAtStr string `yaml:"createdat"`
@@ -53,6 +188,11 @@ type Entry struct {
 	Text         string `gorm:"size:4096"`
 }
 
+type Urge struct {
+	gorm.Model
+	UserID int64
+}
+
 type Task struct {
 	gorm.Model   `yaml:"-"`
 	CreatedAtStr string    `yaml:"createdat"`
@@ -64,12 +204,182 @@ type Task struct {
 	Done         time.Time `gorm:"autoUpdateTime"`
 	Text         string
 	IsDone       bool
+	HabitID      uint   `yaml:"-"`
+	Category     string `yaml:"-"`               // asked with /task, kept when rerolling
+	Status       string `gorm:"default:pending"` // pending, done, expired, skipped, rerolled or rejected
+	Deadline     time.Time
+	ProofText    string `gorm:"size:1024"`
+	ProofFileID  string `yaml:"-"`
+	ProofStatus  string // empty (no proof), pending, approved or rejected
+}
+
+type Habit struct {
+	gorm.Model
+	UserID     int64
+	Name       string
+	Frequency  int // times per week, 7 is daily
+	Points     int
+	IsArchived bool
+}
+
+// Period returns the start of the day (daily habits) or of the week (other habits) containing t
+func (h Habit) Period(t time.Time) time.Time {
+	t = t.Local()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+
+	if h.IsDaily() {
+		return day
+	}
+
+	// weeks start on monday
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+// Target returns the number of completions needed in a period
+func (h Habit) Target() int {
+	if h.IsDaily() {
+		return 1
+	}
+
+	return h.Frequency
+}
+
+func (h Habit) IsDaily() bool {
+	return h.Frequency >= 7
 }
 
 type TaskData struct {
 	gorm.Model
-	Points int
-	Task   string
+	Points     int
+	Task       string // legacy locale key, used when there is no text
+	Category   string
+	Difficulty int
+	Duration   int // minutes
+	IsEnabled  bool `gorm:"default:true"`
+	// a photo or a note is asked before marking the task as done
+	RequiresProof bool
+	Texts         []TaskText
+}
+
+// Text returns the task text in the given language, or in any language if it's missing
+func (t TaskData) Text(language string) string {
+	for _, text := range t.Texts {
+		if text.Language == language {
+			return text.Text
+		}
+	}
+
+	if len(t.Texts) > 0 {
+		return t.Texts[0].Text
+	}
+
+	return ""
+}
+
+type TaskText struct {
+	gorm.Model
+	TaskDataID uint
+	Language   string
+	Text       string
+}
+
+type Challenge struct {
+	gorm.Model
+	Name       string
+	Category   string // task category counted, any if empty
+	Tasks      int    // done tasks to reach
+	CheckIns   int    // days with at least one check-in to reach
+	Points     int    // reward when both are reached
+	Start      time.Time
+	End        time.Time
+	IsFinished bool // results have been posted
+}
+
+type ChallengeParticipant struct {
+	gorm.Model
+	ChallengeID uint
+	UserID      int64
+	ChatID      int64
+	MessageID   int // live progress message
+	IsCompleted bool
+}
+
+// Conversation is the state of a multi-step flow of a user, persisted so the flow resumes after a restart,
+// rows are only written by the last step of the flow
+type Conversation struct {
+	UserID    int64 `gorm:"primaryKey"`
+	Flow      string
+	State     string
+	Data      map[string]string `gorm:"serializer:json"`
+	ChatID    int64
+	MessageID int // message edited at each step
+	ExpiresAt time.Time
+	UpdatedAt time.Time
+}
+
+func (conv Conversation) MessageSig() (string, int64) {
+	return strconv.Itoa(conv.MessageID), conv.ChatID
+}
+
+// Setting overrides a bot.yml config value, set with /config
+type Setting struct {
+	Key       string `gorm:"primaryKey"`
+	Value     string
+	UpdatedAt time.Time
+}
+
+// SettingChange is the audit log of /config set
+type SettingChange struct {
+	gorm.Model
+	UserID int64
+	Key    string `gorm:"index"`
+	Old    string
+	New    string
+}
+
+type Broadcast struct {
+	gorm.Model
+	AuthorID    int64
+	Text        string `gorm:"size:4096"` // markdown, caption of the media if any
+	MediaType   string // photo, video, animation, document or empty
+	FileID      string
+	Language    string // audience filters, ignored if empty
+	Journey     string // yes (running journey) or no
+	RankSystem  string
+	Inactive    int // days without entry nor task
+	ScheduledAt time.Time
+	Status      string // draft, scheduled, sending, done or canceled
+	Sent        int
+	Failed      int
+	Blocked     int
+	FinishedAt  time.Time
+}
+
+type BroadcastDelivery struct {
+	gorm.Model
+	BroadcastID uint `gorm:"index"`
+	UserID      int64
+	Status      string // sent, failed or blocked
+	Error       string
+}
+
+// ErrorLog is an error returned (or a panic) by a handler, a job or a database call
+type ErrorLog struct {
+	gorm.Model
+	UserID   int64
+	Command  string
+	Callback string // unique and data
+	Kind     string `gorm:"index"` // where and what, without the numbers
+	Error    string `gorm:"size:4096"`
+	Stack    string `gorm:"size:16384"`
+	Reported bool   // included in a digest, or of a kind already reported
+}
+
+// CallbackToken keeps the state of a button when it doesn't fit in the callback data
+type CallbackToken struct {
+	Token     string `gorm:"primaryKey"`
+	Value     string
+	ExpiresAt time.Time
 }
 
 type Activity struct {